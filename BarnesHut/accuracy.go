@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// AccuracyReport summarizes the relative force error of the tree walk at one theta
+// against direct summation, and how much faster the tree walk was.
+type AccuracyReport struct {
+	theta       float64
+	median      float64
+	percentile  float64 // 99th percentile
+	max         float64
+	tree_time   time.Duration
+	direct_time time.Duration
+}
+
+// DirectForces creates a ForceFunction that sums the force of every other star on each star exactly.
+// It costs O(n^2), and is meant as a reference for the accuracy of the tree walk.
+// Input: the number of goroutines to spread the stars over (NumCPU if not positive).
+// Output: the corresponding ForceFunction.
+func DirectForces(num_procs int) ForceFunction {
+	if num_procs <= 0 {
+		num_procs = runtime.NumCPU()
+	}
+
+	return func(u *Universe, active []*Star) {
+		forces := u.DirectNetForces(active, num_procs)
+		for i, s := range active {
+			s.acceleration = OrderedPair{forces[i].x / s.mass, forces[i].y / s.mass}
+		}
+	}
+}
+
+// DirectNetForces computes the net force of all stars of a universe on each of the given stars by direct summation.
+// Input: a Universe object, the stars to compute the force on and the number of goroutines to use.
+// Output: the net force on every given star, in the same order.
+func (u *Universe) DirectNetForces(active []*Star, num_procs int) []OrderedPair {
+	forces := make([]OrderedPair, len(active))
+
+	SplitAmongProcs(len(active), num_procs, func(start, end int) {
+		for i := start; i < end; i++ {
+			for _, s := range u.stars {
+				if !active[i].IsSelf(s) {
+					forces[i].AddNewForce(active[i].ComputeForce(s, u.softening))
+				}
+			}
+		}
+	})
+
+	return forces
+}
+
+// TreeNetForces computes the net force on every star of a universe with the Barnes-Hut tree walk.
+// Input: a Universe object, a theta parameter and the number of goroutines to use.
+// Output: the net force on every star, in the order of the stars.
+func (u *Universe) TreeNetForces(theta float64, num_procs int) []OrderedPair {
+	ft := u.BuildFlatTree()
+	forces := make([]OrderedPair, len(u.stars))
+
+	SplitAmongProcs(len(u.stars), num_procs, func(start, end int) {
+		for i := start; i < end; i++ {
+			forces[i] = ft.NetForce(u.stars[i], theta)
+		}
+	})
+
+	return forces
+}
+
+// CompareAccuracy measures the force error of the tree walk at several values of theta.
+// Input: a Universe object, the values of theta to try and the number of goroutines to use.
+// Output: one AccuracyReport per theta.
+func CompareAccuracy(u *Universe, thetas []float64, num_procs int) []AccuracyReport {
+	if num_procs <= 0 {
+		num_procs = runtime.NumCPU()
+	}
+
+	start := time.Now()
+	direct := u.DirectNetForces(u.stars, num_procs)
+	direct_time := time.Since(start)
+
+	reports := make([]AccuracyReport, len(thetas))
+	for k, theta := range thetas {
+		start = time.Now()
+		tree := u.TreeNetForces(theta, num_procs)
+		tree_time := time.Since(start)
+
+		errors := RelativeErrors(tree, direct)
+		sort.Float64s(errors)
+
+		reports[k] = AccuracyReport{
+			theta:       theta,
+			median:      Percentile(errors, 0.5),
+			percentile:  Percentile(errors, 0.99),
+			max:         Percentile(errors, 1),
+			tree_time:   tree_time,
+			direct_time: direct_time,
+		}
+	}
+
+	return reports
+}
+
+// RelativeErrors computes |approximate - exact| / |exact| for every pair of force vectors.
+// Stars with no exact force at all are skipped.
+func RelativeErrors(approximate, exact []OrderedPair) []float64 {
+	errors := make([]float64, 0, len(exact))
+	for i := range exact {
+		norm := math.Hypot(exact[i].x, exact[i].y)
+		if norm > 0 {
+			errors = append(errors, math.Hypot(approximate[i].x-exact[i].x, approximate[i].y-exact[i].y)/norm)
+		}
+	}
+
+	return errors
+}
+
+// Percentile interpolates the p'th quantile (between 0 and 1) of a sorted slice.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	position := p * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	fraction := position - float64(lower)
+
+	return sorted[lower]*(1-fraction) + sorted[upper]*fraction
+}
+
+// Speedup is how many times faster the tree walk was than direct summation.
+func (r AccuracyReport) Speedup() float64 {
+	return r.direct_time.Seconds() / r.tree_time.Seconds()
+}
+
+// WriteAccuracyReport writes a slice of AccuracyReport to a CSV file.
+// Input: a slice of AccuracyReport and a file name.
+// Output: an error if the file could not be written.
+func WriteAccuracyReport(reports []AccuracyReport, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"theta", "median_error", "p99_error", "max_error", "tree_seconds", "direct_seconds", "speedup"})
+	for _, r := range reports {
+		w.Write([]string{
+			FormatFloat(r.theta),
+			FormatFloat(r.median),
+			FormatFloat(r.percentile),
+			FormatFloat(r.max),
+			FormatFloat(r.tree_time.Seconds()),
+			FormatFloat(r.direct_time.Seconds()),
+			FormatFloat(r.Speedup()),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
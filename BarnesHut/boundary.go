@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// EscapeEvent records a star that left the square [0, width] x [0, width] of a Universe
+// and was removed under the "remove" escape policy.
+type EscapeEvent struct {
+	generation int
+	star       *Star
+}
+
+// ValidEscapePolicy checks whether an escape policy is known.
+// The policies are "keep" (or empty), which lets stars leave the universe while the quadtree grows to contain them,
+// "remove", which deletes stars that leave and records them, "reflect", which bounces them off the walls,
+// and "periodic", which brings them back on the opposite side and makes the forces periodic too.
+func ValidEscapePolicy(policy string) bool {
+	switch policy {
+	case "", "keep", "remove", "reflect", "periodic":
+		return true
+	}
+
+	return false
+}
+
+// ApplyEscapePolicy handles the stars that have left the universe according to its escape policy.
+// Input: a Universe object and the current generation.
+// Output: true if any star was removed or reflected, in which case the accelerations of the universe are out of date.
+// Removed stars are appended to the escapes of the universe. Wrapping a star around a periodic universe
+// leaves the periodic forces unchanged, so it does not count.
+func (u *Universe) ApplyEscapePolicy(generation int) bool {
+	changed := false
+	switch u.escape_policy {
+	case "remove":
+		remaining := u.stars[:0]
+		for _, s := range u.stars {
+			if u.InUniverse(s) {
+				remaining = append(remaining, s)
+			} else {
+				u.escapes = append(u.escapes, EscapeEvent{generation, s})
+				changed = true
+			}
+		}
+		u.stars = remaining
+	case "reflect":
+		for _, s := range u.stars {
+			if !u.InUniverse(s) {
+				s.position.x, s.velocity.x = Reflect(s.position.x, s.velocity.x, u.width)
+				s.position.y, s.velocity.y = Reflect(s.position.y, s.velocity.y, u.width)
+				changed = true
+			}
+		}
+	case "periodic":
+		for _, s := range u.stars {
+			s.position.x = Wrap(s.position.x, u.width)
+			s.position.y = Wrap(s.position.y, u.width)
+		}
+	}
+
+	return changed
+}
+
+// InUniverse checks whether a star lies within the square [0, width] x [0, width] of the universe.
+func (u *Universe) InUniverse(s *Star) bool {
+	root := Node{sector: Quadrant{x: 0, y: u.width, width: u.width}}
+
+	return root.InField(s)
+}
+
+// Reflect mirrors a coordinate that has crossed a wall at 0 or at width back into the universe
+// and reverses the corresponding velocity component.
+// Input: a coordinate, the velocity along it and the width of the universe.
+// Output: the reflected coordinate and velocity.
+func Reflect(p, v, width float64) (float64, float64) {
+	if p < 0 {
+		p = -p
+		v = -v
+	} else if p > width {
+		p = 2*width - p
+		v = -v
+	}
+
+	// a star that crossed the whole universe in one step is placed on the wall it was heading to
+	if p < 0 {
+		p = 0
+	} else if p > width {
+		p = width
+	}
+
+	return p, v
+}
+
+// WriteEscapes writes the stars removed from a universe to a CSV file.
+// Input: a slice of EscapeEvent and a file name.
+// Output: an error if the file could not be written.
+func WriteEscapes(escapes []EscapeEvent, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"generation", "id", "x", "y", "vx", "vy", "mass"})
+	for _, e := range escapes {
+		w.Write([]string{
+			strconv.Itoa(e.generation),
+			strconv.Itoa(e.star.id),
+			FormatFloat(e.star.position.x),
+			FormatFloat(e.star.position.y),
+			FormatFloat(e.star.velocity.x),
+			FormatFloat(e.star.velocity.y),
+			FormatFloat(e.star.mass),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
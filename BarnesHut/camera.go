@@ -0,0 +1,140 @@
+package main
+
+import "math"
+
+// Viewport is the square region of a Universe that is drawn on the canvas, given by its center and width.
+type Viewport struct {
+	center OrderedPair
+	width  float64
+}
+
+// Camera2D decides which Viewport of a Universe each frame shows. The modes are
+// "fixed" (or empty), which always shows the same viewport, "fit", which shows the bounding box of the stars,
+// "com", which follows the center of mass, and "star", which follows the star with the given id.
+// Instead of jumping to the viewport of its mode, the camera moves a fraction of the way there every frame,
+// so it pans and zooms smoothly. The first frame starts from the whole universe.
+type Camera2D struct {
+	mode      string
+	id        int      // the star followed in the "star" mode
+	view      Viewport // the viewport of the "fixed" mode; only its width is used when following
+	margin    float64  // extra room around the bounding box in the "fit" mode, as a fraction of its width
+	smoothing float64  // the fraction of the way to its target that the camera is still away from after a frame
+	current   Viewport
+	started   bool
+}
+
+// ValidCameraMode checks whether a camera mode is known.
+func ValidCameraMode(mode string) bool {
+	switch mode {
+	case "", "fixed", "fit", "com", "star":
+		return true
+	}
+
+	return false
+}
+
+// NewCamera2D creates a camera. A zero width stands for the width of the universe, centered on the universe.
+// Input: a mode, the id of the followed star, the viewport of the fixed mode, a margin and a smoothing factor in [0, 1).
+// Output: a pointer to the camera, which keeps track of its viewport from frame to frame.
+func NewCamera2D(mode string, id int, view Viewport, margin, smoothing float64) *Camera2D {
+	if !ValidCameraMode(mode) {
+		panic("Error: unknown camera mode " + mode + ".")
+	}
+	if smoothing < 0 || smoothing >= 1 {
+		panic("Error: camera smoothing must be in [0, 1).")
+	}
+
+	return &Camera2D{mode: mode, id: id, view: view, margin: margin, smoothing: smoothing}
+}
+
+// FullView is the viewport showing the square [0, width] of a universe.
+func (u *Universe) FullView() Viewport {
+	return Viewport{OrderedPair{u.width / 2, u.width / 2}, u.width}
+}
+
+// Target computes the viewport that the mode of the camera asks for in a universe.
+// When there is nothing to fit or follow, the camera stays where it is.
+func (cam *Camera2D) Target(u *Universe) Viewport {
+	target := cam.view
+	if target.width == 0 {
+		target = u.FullView()
+	}
+
+	switch cam.mode {
+	case "fit":
+		if len(u.stars) == 0 {
+			return cam.current
+		}
+		min_x, max_x := math.Inf(1), math.Inf(-1)
+		min_y, max_y := math.Inf(1), math.Inf(-1)
+		for _, s := range u.stars {
+			min_x, max_x = math.Min(min_x, s.position.x), math.Max(max_x, s.position.x)
+			min_y, max_y = math.Min(min_y, s.position.y), math.Max(max_y, s.position.y)
+		}
+		target.center = OrderedPair{(min_x + max_x) / 2, (min_y + max_y) / 2}
+		// a single star has no extent, so it keeps the width of the fixed viewport
+		if width := math.Max(max_x-min_x, max_y-min_y); width > 0 {
+			target.width = width * (1 + cam.margin)
+		}
+	case "com":
+		var mass float64
+		var com OrderedPair
+		for _, s := range u.stars {
+			com = CalculateCOM(com, s.position, mass, s.mass)
+			mass += s.mass
+		}
+		if mass == 0 {
+			return cam.current
+		}
+		target.center = com
+	case "star":
+		found := false
+		for _, s := range u.stars {
+			if s.id == cam.id {
+				target.center = s.position
+				found = true
+				break
+			}
+		}
+		if !found {
+			return cam.current
+		}
+	}
+
+	return target
+}
+
+// Update moves the camera towards its target for a new frame. The width is eased geometrically,
+// so that zooming in by a factor takes as long as zooming out by it.
+// Input: the universe about to be drawn.
+// Output: the viewport of the frame. A nil camera always shows the whole universe.
+func (cam *Camera2D) Update(u *Universe) Viewport {
+	if cam == nil {
+		return u.FullView()
+	}
+	if !cam.started {
+		cam.current = u.FullView()
+		cam.started = true
+	}
+
+	target := cam.Target(u)
+	a := cam.smoothing
+	cam.current.center.x = target.center.x + a*(cam.current.center.x-target.center.x)
+	cam.current.center.y = target.center.y + a*(cam.current.center.y-target.center.y)
+	cam.current.width = target.width * math.Pow(cam.current.width/target.width, a)
+
+	return cam.current
+}
+
+// ToCanvas maps a point of the universe to a point of a canvas of the given width showing the viewport.
+func (v Viewport) ToCanvas(p OrderedPair, canvas_width int) (float64, float64) {
+	x := (p.x - (v.center.x - v.width/2)) / v.width * float64(canvas_width)
+	y := (p.y - (v.center.y - v.width/2)) / v.width * float64(canvas_width)
+
+	return x, y
+}
+
+// Scale converts a length of the universe to a number of pixels of a canvas of the given width showing the viewport.
+func (v Viewport) Scale(length float64, canvas_width int) float64 {
+	return length / v.width * float64(canvas_width)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// usage describes every subcommand. The flags of a subcommand are listed by BarnesHut <command> -h.
+const usage = `Usage: BarnesHut <command> [flags] <file>
+
+Commands:
+  run [flags] <scenario>      simulate a scenario file, or one of the shipped scenarios
+                              (galaxy, jupiter, collision, merger, disk, encounter, periodic, collision3d)
+  resume [flags] <snapshot>   continue a run from a snapshot; needs -scenario, or -dt and -generations
+  render [flags] <snapshot>   draw a snapshot to a PNG image
+  analyze [flags] <scenario>  report the conserved quantities and the accuracy of the tree walk
+                              for the initial universe of a scenario, or of a snapshot with -snapshot
+`
+
+// UsageError reports a command line that could not be understood. It carries the flags of the subcommand, if any,
+// so that their usage can be printed along with the error.
+type UsageError struct {
+	message string
+	flags   *flag.FlagSet
+}
+
+func (e UsageError) Error() string {
+	return e.message
+}
+
+// PrintUsage prints the usage of the subcommand of the error, or of every subcommand if it had none.
+func (e UsageError) PrintUsage(w io.Writer) {
+	if e.flags == nil {
+		fmt.Fprint(w, usage)
+		return
+	}
+	e.flags.SetOutput(w)
+	e.flags.Usage()
+}
+
+// RunOptions holds the flags of a subcommand. The flags that were given on the command line are recorded in set,
+// so that only those override the settings of a scenario.
+type RunOptions struct {
+	theta             float64
+	time              float64
+	num_gens          int
+	canvas_width      int
+	drawing_frequency int
+	scaling_factor    float64
+	output            string
+	format            string
+	encoder           string
+	seed              int64
+	scenario          string
+	mode              string
+	colormap          string
+	overlay           bool
+	azimuth           float64
+	elevation         float64
+	snapshot          bool
+	progress          time.Duration
+	set               map[string]bool
+}
+
+// NewCommandFlags creates the flags of a subcommand, bound to the fields of opts.
+// Input: the name of the subcommand and the options to fill.
+// Output: the FlagSet, or nil if the subcommand is unknown.
+func NewCommandFlags(command string, opts *RunOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	var synopsis string
+
+	switch command {
+	case "run", "resume":
+		fs.Float64Var(&opts.theta, "theta", 0.5, "opening angle of the tree walk")
+		fs.Float64Var(&opts.time, "dt", 0, "time step of a generation, in seconds")
+		fs.IntVar(&opts.num_gens, "generations", 0, "number of generations to simulate")
+		fs.IntVar(&opts.canvas_width, "canvas", 1000, "width of the frames, in pixels")
+		fs.IntVar(&opts.drawing_frequency, "frequency", 100, "number of generations between two frames")
+		fs.Float64Var(&opts.scaling_factor, "scale", 1, "factor by which stars are enlarged when drawn")
+		fs.StringVar(&opts.format, "format", "", "output format of the frames: gif, png, apng or pipe")
+		fs.DurationVar(&opts.progress, "progress", default_progress_interval, "wall-clock time between progress reports, or 0 for none")
+		fs.StringVar(&opts.encoder, "encoder", "", "command line of the encoder of the pipe output, which may refer to {width}, {height}, {fps} and {name}")
+		if command == "run" {
+			synopsis = "run [flags] <scenario>"
+			fs.StringVar(&opts.output, "o", "", "path and base name of the output files (default: the scenario name)")
+			fs.Int64Var(&opts.seed, "seed", 0, "seed of the random galaxies")
+			fs.Float64Var(&opts.azimuth, "azimuth", 0, "rotation of the view of a scenario with three dimensions about its z axis, in degrees")
+			fs.Float64Var(&opts.elevation, "elevation", 0, "angle of the view of a scenario with three dimensions above its z = 0 plane, in degrees")
+		} else {
+			synopsis = "resume [flags] <snapshot>"
+			fs.StringVar(&opts.output, "o", "resume", "path and base name of the output files")
+			fs.StringVar(&opts.scenario, "scenario", "", "scenario file whose settings the run continues with")
+		}
+	case "render":
+		synopsis = "render [flags] <snapshot>"
+		fs.IntVar(&opts.canvas_width, "canvas", 1000, "width of the image, in pixels")
+		fs.Float64Var(&opts.scaling_factor, "scale", 1, "factor by which stars are enlarged when drawn")
+		fs.StringVar(&opts.mode, "mode", "stars", "render mode: stars, density or velocity")
+		fs.StringVar(&opts.colormap, "colormap", "heat", "colormap of the density mode: heat, viridis or gray")
+		fs.BoolVar(&opts.overlay, "overlay", false, "outline the sectors of the quadtree")
+		fs.StringVar(&opts.output, "o", "", "path and base name of the image (default: the snapshot name)")
+	case "analyze":
+		synopsis = "analyze [flags] <scenario>"
+		fs.BoolVar(&opts.snapshot, "snapshot", false, "the file is a snapshot rather than a scenario")
+		fs.Int64Var(&opts.seed, "seed", 0, "seed of the random galaxies of a scenario")
+		fs.StringVar(&opts.output, "o", "", "path and base name of the accuracy report (default: the file name)")
+	default:
+		return nil
+	}
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: BarnesHut "+synopsis)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// ParseCommand parses the arguments of a subcommand, which must end with exactly one file.
+// Input: the name of the subcommand and its arguments.
+// Output: the options, the file, and a UsageError if the arguments could not be understood.
+// Asking for help gives flag.ErrHelp, and the caller prints the usage.
+func ParseCommand(command string, args []string) (*RunOptions, string, error) {
+	var opts RunOptions
+	fs := NewCommandFlags(command, &opts)
+	if fs == nil {
+		return nil, "", UsageError{message: "unknown command " + command}
+	}
+
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, "", err
+		}
+		return nil, "", UsageError{err.Error(), fs}
+	}
+	if fs.NArg() == 0 {
+		return nil, "", UsageError{command + " needs a file", fs}
+	}
+	if fs.NArg() > 1 {
+		return nil, "", UsageError{command + " needs exactly one file, got: " + strings.Join(fs.Args(), " "), fs}
+	}
+
+	opts.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		opts.set[f.Name] = true
+	})
+	if opts.set["theta"] && opts.theta < 0 {
+		return nil, "", UsageError{fmt.Sprintf("-theta must not be negative, got %g", opts.theta), fs}
+	}
+	if opts.set["format"] && !ValidOutputFormat(opts.format) {
+		return nil, "", UsageError{"unknown output format " + opts.format, fs}
+	}
+	if opts.set["mode"] && !ValidRenderMode(opts.mode) {
+		return nil, "", UsageError{"unknown render mode " + opts.mode, fs}
+	}
+	if opts.set["colormap"] && !ValidColormap(opts.colormap) {
+		return nil, "", UsageError{"unknown colormap " + opts.colormap, fs}
+	}
+
+	return &opts, fs.Arg(0), nil
+}
+
+// Apply overrides the settings of a scenario with the flags given on the command line.
+// The scenario should be validated again afterwards.
+func (opts *RunOptions) Apply(sc *Scenario) {
+	if opts.set["theta"] {
+		sc.Integration.Theta = opts.theta
+	}
+	if opts.set["dt"] {
+		sc.Integration.Time = opts.time
+	}
+	if opts.set["generations"] {
+		sc.Integration.NumGens = opts.num_gens
+	}
+	if opts.set["canvas"] {
+		sc.Rendering.CanvasWidth = opts.canvas_width
+	}
+	if opts.set["frequency"] {
+		sc.Rendering.DrawingFrequency = opts.drawing_frequency
+	}
+	if opts.set["scale"] {
+		sc.Rendering.ScalingFactor = opts.scaling_factor
+	}
+	if opts.set["format"] {
+		sc.Rendering.Output = opts.format
+	}
+	if opts.set["azimuth"] {
+		sc.Rendering.Azimuth = opts.azimuth
+	}
+	if opts.set["elevation"] {
+		sc.Rendering.Elevation = opts.elevation
+	}
+	if opts.set["encoder"] {
+		sc.Rendering.Command = strings.Fields(opts.encoder)
+	}
+	if opts.set["seed"] {
+		sc.Seed = opts.seed
+	}
+	if opts.set["o"] {
+		sc.Name = opts.output
+	}
+}
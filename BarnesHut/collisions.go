@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"strconv"
+)
+
+// MergerEvent records two stars that overlapped and were merged, as they were just before the merger.
+// The primary is the heavier star, which survives the merger with the combined mass and keeps its id.
+type MergerEvent struct {
+	generation int
+	primary    Star
+	secondary  Star
+}
+
+// ValidCollisionPolicy checks whether a collision policy is known.
+// The policies are "ignore" (or empty), which lets stars pass through each other, "merge", which combines
+// overlapping stars into one conserving mass and momentum, and "bounce", which collides them elastically.
+func ValidCollisionPolicy(policy string) bool {
+	switch policy {
+	case "", "ignore", "merge", "bounce":
+		return true
+	}
+
+	return false
+}
+
+// ApplyCollisionPolicy finds every pair of overlapping stars with the quadtree and resolves them according to
+// the collision policy of the universe. Each star takes part in at most one collision per generation.
+// Input: a Universe object and the current generation.
+// Output: true if any star was merged, in which case the accelerations of the universe are out of date.
+func (u *Universe) ApplyCollisionPolicy(generation int) bool {
+	if u.collision_policy == "" || u.collision_policy == "ignore" {
+		return false
+	}
+
+	pairs := u.FindOverlaps()
+	if len(pairs) == 0 {
+		return false
+	}
+
+	resolved := make(map[*Star]bool)
+	absorbed := make(map[*Star]bool)
+	for _, pair := range pairs {
+		s1, s2 := pair[0], pair[1]
+		if resolved[s1] || resolved[s2] {
+			continue
+		}
+		resolved[s1], resolved[s2] = true, true
+
+		if u.collision_policy == "bounce" {
+			Bounce(s1, s2)
+			continue
+		}
+		if s2.mass > s1.mass {
+			s1, s2 = s2, s1
+		}
+		u.mergers = append(u.mergers, MergerEvent{generation, *s1, *s2})
+		s1.Absorb(s2)
+		absorbed[s2] = true
+	}
+
+	if len(absorbed) == 0 {
+		return false
+	}
+	remaining := u.stars[:0]
+	for _, s := range u.stars {
+		if !absorbed[s] {
+			remaining = append(remaining, s)
+		}
+	}
+	u.stars = remaining
+
+	return true
+}
+
+// FindOverlaps searches the quadtree around every star for stars whose radii overlap with it.
+// Input: a Universe object.
+// Output: every overlapping pair once, in the order of the stars of the universe.
+func (u *Universe) FindOverlaps() [][2]*Star {
+	qt := u.BuildQuadTree()
+	max_radius := 0.0
+	index := make(map[*Star]int, len(u.stars))
+	for i, s := range u.stars {
+		max_radius = math.Max(max_radius, s.radius)
+		index[s] = i
+	}
+
+	pairs := make([][2]*Star, 0)
+	for i, s := range u.stars {
+		for _, other := range qt.FindNeighbors(s.position, s.radius+max_radius) {
+			if index[other] > i && Distance(s.position, other.position) < s.radius+other.radius {
+				pairs = append(pairs, [2]*Star{s, other})
+			}
+		}
+	}
+
+	return pairs
+}
+
+// FindNeighbors collects the stars of the quadtree that lie within a distance of a point,
+// only descending into nodes whose sector reaches that far.
+// Input: a quadtree, a point and a search radius.
+// Output: the stars found.
+func (qt *QuadTree) FindNeighbors(p OrderedPair, radius float64) []*Star {
+	neighbors := make([]*Star, 0)
+	stack := []*Node{qt.root}
+
+	for len(stack) != 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur.star == nil || !cur.sector.Reaches(p, radius) {
+			continue
+		}
+		if cur.children == nil {
+			if Distance(p, cur.star.position) <= radius {
+				neighbors = append(neighbors, cur.star)
+			}
+		} else {
+			stack = append(stack, cur.children...)
+		}
+	}
+
+	return neighbors
+}
+
+// Reaches checks whether any point of a quadrant lies within a distance of a point.
+func (q Quadrant) Reaches(p OrderedPair, radius float64) bool {
+	nearest_x := math.Max(q.x, math.Min(p.x, q.x+q.width))
+	nearest_y := math.Max(q.y-q.width, math.Min(p.y, q.y))
+
+	return Distance(p, OrderedPair{nearest_x, nearest_y}) <= radius
+}
+
+// Absorb merges star s2 into star s: the mass and momentum are conserved, the position becomes the center of mass,
+// and the radius is that of a sphere with the combined volume. The absorbed star is left with no mass.
+func (s *Star) Absorb(s2 *Star) {
+	total_mass := s.mass + s2.mass
+
+	s.position = CalculateCOM(s.position, s2.position, s.mass, s2.mass)
+	s.velocity = CalculateCOM(s.velocity, s2.velocity, s.mass, s2.mass)
+	s.acceleration = CalculateCOM(s.acceleration, s2.acceleration, s.mass, s2.mass)
+	s.radius = math.Cbrt(s.radius*s.radius*s.radius + s2.radius*s2.radius*s2.radius)
+	s.mass = total_mass
+
+	s2.mass = 0
+}
+
+// Bounce collides two stars elastically along the line between their centers,
+// if they are moving towards each other. Momentum and kinetic energy are conserved.
+func Bounce(s1, s2 *Star) {
+	d := Distance(s1.position, s2.position)
+	if d == 0 {
+		return
+	}
+	nx := (s2.position.x - s1.position.x) / d
+	ny := (s2.position.y - s1.position.y) / d
+
+	// closing speed along the normal
+	closing := (s1.velocity.x-s2.velocity.x)*nx + (s1.velocity.y-s2.velocity.y)*ny
+	if closing <= 0 {
+		return
+	}
+
+	total_mass := s1.mass + s2.mass
+	s1.velocity.x -= 2 * s2.mass / total_mass * closing * nx
+	s1.velocity.y -= 2 * s2.mass / total_mass * closing * ny
+	s2.velocity.x += 2 * s1.mass / total_mass * closing * nx
+	s2.velocity.y += 2 * s1.mass / total_mass * closing * ny
+}
+
+// WriteMergers writes the merger events of a universe to a CSV file.
+// Input: a slice of MergerEvent and a file name.
+// Output: an error if the file could not be written.
+func WriteMergers(mergers []MergerEvent, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"generation", "primary_id", "secondary_id", "x", "y", "primary_mass", "secondary_mass", "merged_mass"})
+	for _, e := range mergers {
+		position := CalculateCOM(e.primary.position, e.secondary.position, e.primary.mass, e.secondary.mass)
+		w.Write([]string{
+			strconv.Itoa(e.generation),
+			strconv.Itoa(e.primary.id),
+			strconv.Itoa(e.secondary.id),
+			FormatFloat(position.x),
+			FormatFloat(position.y),
+			FormatFloat(e.primary.mass),
+			FormatFloat(e.secondary.mass),
+			FormatFloat(e.primary.mass + e.secondary.mass),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
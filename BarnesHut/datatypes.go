@@ -9,20 +9,55 @@ const blackhole_mass = 8e36 // mass of black hole -- don't change!
 // Universe contains a slice of pointers to stars and a width parameter.
 // We conceptualize the universe as a square -- stars may go outside the universe
 // but the width dictates relative distances when drawing the universe.
+// The softening smooths gravity at short range and can be set per scenario.
+// The escape policy decides what happens to stars that leave the square, and escapes records the removed ones.
+// The collision policy decides what happens to overlapping stars, and mergers records the merged ones.
+// If quadrupole is set, the tree walk adds the quadrupole moments of internal nodes to their far-field force.
+// The integrator names the scheme the universe is evolved with, so that a run resumed from a snapshot keeps it.
 type Universe struct {
-	stars []*Star
-	width float64
+	stars            []*Star
+	width            float64
+	softening        Softening
+	escape_policy    string
+	escapes          []EscapeEvent
+	quadrupole       bool
+	collision_policy string
+	mergers          []MergerEvent
+	integrator       string
+	time             float64
+}
+
+// Universe3D is the three dimensional counterpart of Universe.
+// We conceptualize the universe as a cube of the given width whose x and y range over [0, width]
+// and whose z ranges over [-width/2, width/2], so that galaxy disks lie in its middle plane.
+type Universe3D struct {
+	stars     []*Star3D
+	width     float64
+	softening Softening
 }
 
 // Galaxy is a potentially useful object holding a list of star positions
 type Galaxy []*Star
 
+// Galaxy3D is a slice of star pointers in three dimensions.
+type Galaxy3D []*Star3D
+
 // Star is analogous to the "Body" object from the jupiter simulations.
+// The id identifies a star for its whole life, through copies and snapshots; zero means none has been assigned yet.
 type Star struct {
 	position, velocity, acceleration OrderedPair
 	mass                             float64
 	radius                           float64
 	red, blue, green                 uint8
+	id                               int
+}
+
+// Star3D is the three dimensional counterpart of Star.
+type Star3D struct {
+	position, velocity, acceleration Triple
+	mass                             float64
+	radius                           float64
+	red, blue, green                 uint8
 }
 
 //OrderedPair represents a point or vector.
@@ -31,19 +66,34 @@ type OrderedPair struct {
 	y float64
 }
 
+//Triple represents a point or vector in three dimensions.
+type Triple struct {
+	x float64
+	y float64
+	z float64
+}
+
 //QuadTree simply contains a pointer to the root.
 //Another way of doing this would be type QuadTree *Node
+//It also carries the softening of the universe it was built from, so the tree walk can apply it,
+//and whether the quadrupole moments of its internal nodes have been computed.
+//For a periodic universe, period is the width of the box, and zero otherwise.
 type QuadTree struct {
-	root *Node
+	root       *Node
+	softening  Softening
+	quadrupole bool
+	period     float64
 }
 
 //Node object contains a slice of children (this could just as easily be an array of length 4).
 //A node refers to a star. Sometimes, the star will be a "dummy" star, sometimes it is a star in the
 //universe, and sometimes it is nil. Every internal node points to a dummy star.
+//Internal nodes may also hold the quadrupole moment of their stars about the dummy star.
 type Node struct {
-	children []*Node
-	star     *Star
-	sector   Quadrant
+	children   []*Node
+	star       *Star
+	sector     Quadrant
+	quadrupole Quadrupole
 }
 
 //Quadrant is an object representing a sub-square within a larger universe.
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// Diagnostics holds the conserved quantities of a single Universe.
+// The angular momentum is the z component about the origin, since the universe is two dimensional.
+type Diagnostics struct {
+	generation       int
+	time             float64
+	kinetic          float64
+	potential_exact  float64
+	potential_tree   float64
+	momentum         OrderedPair
+	angular_momentum float64
+}
+
+// ComputeDiagnostics computes the conserved quantities of every frequency'th Universe.
+// Input: a slice of Universe objects, a theta parameter and a frequency.
+// Output: a slice of Diagnostics, one for every sampled Universe.
+func ComputeDiagnostics(time_points []*Universe, theta float64, frequency int) []Diagnostics {
+	diagnostics := make([]Diagnostics, 0)
+
+	for i := range time_points {
+		if i%frequency == 0 && time_points[i] != nil {
+			diagnostics = append(diagnostics, time_points[i].ComputeDiagnostics(i, theta))
+		}
+	}
+
+	return diagnostics
+}
+
+// DiagnosticsObserver creates an Observer that computes the conserved quantities of every frequency'th Universe
+// while the simulation is running and appends them to diagnostics.
+// Input: a pointer to the slice of Diagnostics to fill, a theta parameter and a frequency.
+// Output: the Observer.
+func DiagnosticsObserver(diagnostics *[]Diagnostics, theta float64, frequency int) Observer {
+	return func(generation int, u *Universe) {
+		if generation%frequency == 0 {
+			*diagnostics = append(*diagnostics, u.ComputeDiagnostics(generation, theta))
+		}
+	}
+}
+
+// ComputeDiagnostics computes the conserved quantities of a Universe.
+// Input: a Universe object, its generation and a theta parameter.
+// Output: the Diagnostics of the Universe, at the elapsed time of the Universe.
+func (u *Universe) ComputeDiagnostics(generation int, theta float64) Diagnostics {
+	var d Diagnostics
+	d.generation = generation
+	d.time = u.time
+	d.kinetic = u.KineticEnergy()
+	d.potential_exact = u.PotentialEnergy()
+	d.potential_tree = u.TreePotentialEnergy(theta)
+	d.momentum = u.LinearMomentum()
+	d.angular_momentum = u.AngularMomentum()
+
+	return d
+}
+
+// KineticEnergy sums the kinetic energy of all stars in the universe.
+func (u *Universe) KineticEnergy() float64 {
+	var energy float64
+	for _, s := range u.stars {
+		energy += 0.5 * s.mass * (s.velocity.x*s.velocity.x + s.velocity.y*s.velocity.y)
+	}
+
+	return energy
+}
+
+// PotentialEnergy sums the gravitational potential energy over every pair of stars exactly.
+// In a periodic universe, every pair includes the periodic images of the second star.
+func (u *Universe) PotentialEnergy() float64 {
+	var energy float64
+	for i := range u.stars {
+		for j := i + 1; j < len(u.stars); j++ {
+			if u.Periodic() {
+				energy += u.stars[i].ComputePeriodicPotential(u.stars[j], u.softening, u.width)
+			} else {
+				energy += u.stars[i].ComputePotential(u.stars[j], u.softening)
+			}
+		}
+	}
+
+	return energy
+}
+
+// TreePotentialEnergy approximates the gravitational potential energy using a quadtree.
+// Input: a Universe object and a theta parameter.
+// Output: the potential energy, where every pair is counted from both sides and then halved.
+func (u *Universe) TreePotentialEnergy(theta float64) float64 {
+	qt := u.BuildQuadTree()
+
+	var energy float64
+	for _, s := range u.stars {
+		energy += s.ComputeNetPotential(qt, theta)
+	}
+
+	return energy / 2
+}
+
+// LinearMomentum sums the momentum vectors of all stars in the universe.
+func (u *Universe) LinearMomentum() OrderedPair {
+	var momentum OrderedPair
+	for _, s := range u.stars {
+		momentum.x += s.mass * s.velocity.x
+		momentum.y += s.mass * s.velocity.y
+	}
+
+	return momentum
+}
+
+// AngularMomentum sums the angular momentum of all stars in the universe about the origin.
+func (u *Universe) AngularMomentum() float64 {
+	var angular_momentum float64
+	for _, s := range u.stars {
+		angular_momentum += s.mass * (s.position.x*s.velocity.y - s.position.y*s.velocity.x)
+	}
+
+	return angular_momentum
+}
+
+// ComputeNetPotential sums the potential energy of star s with every node accepted by the tree walk.
+// It uses the same BFS traversal and opening criterion as ComputeNetForce.
+// Input: a quadtree and a theta parameter.
+// Output: the potential energy of the given star.
+func (s *Star) ComputeNetPotential(qt *QuadTree, theta float64) float64 {
+	if qt.period > 0 {
+		return s.ComputePeriodicNetPotential(qt, theta)
+	}
+
+	var potential float64
+	queue := make([]*Node, 1)
+	queue[0] = qt.root
+
+	for len(queue) != 0 {
+		cur := queue[0]
+		if cur.children == nil && !s.IsSelf(cur.star) {
+			potential += s.ComputePotential(cur.star, qt.softening)
+		} else if cur.children != nil {
+			param := s.CalculateTheta(cur)
+			if param > theta {
+				for i := range cur.children {
+					if cur.children[i].star != nil {
+						queue = append(queue, cur.children[i])
+					}
+				}
+			} else {
+				potential += s.ComputePotential(cur.star, qt.softening)
+				if qt.quadrupole {
+					potential += s.ComputeQuadrupolePotential(cur)
+				}
+			}
+		}
+		queue = queue[1:]
+	}
+
+	return potential
+}
+
+// ComputePotential computes the softened gravitational potential energy between star s and another star.
+func (s *Star) ComputePotential(new_star *Star, softening Softening) float64 {
+	d := Distance(s.position, new_star.position)
+
+	return -G * s.mass * new_star.mass * softening.PotentialFactor(d)
+}
+
+// WriteDiagnostics writes a time series of Diagnostics to a CSV file.
+// The relative energy error is measured against the first row, using the exact potential energy.
+// Input: a slice of Diagnostics and a file name.
+// Output: an error if the file could not be written.
+func WriteDiagnostics(diagnostics []Diagnostics, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"generation", "time", "kinetic", "potential_exact", "potential_tree", "total_energy", "energy_error", "momentum_x", "momentum_y", "angular_momentum"})
+
+	var initial_energy float64
+	for i, d := range diagnostics {
+		total_energy := d.kinetic + d.potential_exact
+		energy_error := 0.0
+		if i == 0 {
+			initial_energy = total_energy
+		} else if initial_energy != 0 {
+			energy_error = (total_energy - initial_energy) / initial_energy
+		}
+		w.Write([]string{
+			strconv.Itoa(d.generation),
+			FormatFloat(d.time),
+			FormatFloat(d.kinetic),
+			FormatFloat(d.potential_exact),
+			FormatFloat(d.potential_tree),
+			FormatFloat(total_energy),
+			FormatFloat(energy_error),
+			FormatFloat(d.momentum.x),
+			FormatFloat(d.momentum.y),
+			FormatFloat(d.angular_momentum),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// FormatFloat formats a float64 for CSV output without losing precision.
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -9,6 +9,12 @@ import (
 //Every frequency steps, it generates a slice of images corresponding to drawing each Universe on a canvasWidth x canvasWidth canvas.
 //A scaling factor is a final input that is used to scale the stars big enough to see them.
 func AnimateSystem(time_points []*Universe, canvas_width, frequency int, scaling_factor float64) []image.Image {
+	return AnimateSystemWithCamera(time_points, canvas_width, frequency, scaling_factor, nil)
+}
+
+//AnimateSystemWithCamera is AnimateSystem with every frame showing the viewport chosen by a camera.
+//A nil camera shows the whole universe.
+func AnimateSystemWithCamera(time_points []*Universe, canvas_width, frequency int, scaling_factor float64, camera *Camera2D) []image.Image {
 	images := make([]image.Image, 0)
 
 	if len(time_points) == 0 {
@@ -19,7 +25,8 @@ func AnimateSystem(time_points []*Universe, canvas_width, frequency int, scaling
 	for i := range time_points {
 		if i%frequency == 0 {
 			if time_points[i] != nil {
-				images = append(images, time_points[i].DrawToCanvas(canvas_width, scaling_factor))
+				view := camera.Update(time_points[i])
+				images = append(images, time_points[i].DrawViewToCanvas(canvas_width, scaling_factor, view, RenderStyle{}, nil, 0))
 			}
 		}
 	}
@@ -27,9 +34,47 @@ func AnimateSystem(time_points []*Universe, canvas_width, frequency int, scaling
 	return images
 }
 
+//DrawingObserver creates an Observer that draws every frequency'th Universe while the simulation is running
+//and appends the image to images, so the Universe objects themselves never have to be kept.
+func DrawingObserver(images *[]image.Image, canvas_width, frequency int, scaling_factor float64) Observer {
+	return CameraDrawingObserver(ImageListSink{images}, canvas_width, frequency, scaling_factor, nil, RenderStyle{}, nil, 0)
+}
+
+//TrailsDrawingObserver works like DrawingObserver, but draws the last trail_length points of every trajectory
+//behind its star. It must come after the TrajectoryObserver filling the trajectories.
+func TrailsDrawingObserver(images *[]image.Image, canvas_width, frequency int, scaling_factor float64, trajectories Trajectories, trail_length int) Observer {
+	return CameraDrawingObserver(ImageListSink{images}, canvas_width, frequency, scaling_factor, nil, RenderStyle{}, trajectories, trail_length)
+}
+
+//CameraDrawingObserver works like TrailsDrawingObserver, but every frame shows the viewport chosen by a camera,
+//drawn in a render style, and goes to a FrameSink as soon as it is drawn.
+//The camera only moves on the frames that are drawn. A nil camera shows the whole universe.
+//A frame the sink fails to write is dropped; the sink reports the error when it is closed.
+func CameraDrawingObserver(sink FrameSink, canvas_width, frequency int, scaling_factor float64, camera *Camera2D, style RenderStyle, trajectories Trajectories, trail_length int) Observer {
+	return func(generation int, u *Universe) {
+		if generation%frequency == 0 {
+			view := camera.Update(u)
+			sink.WriteFrame(u.DrawViewToCanvas(canvas_width, scaling_factor, view, style, trajectories, trail_length))
+		}
+	}
+}
+
 //DrawToCanvas generates the image corresponding to a canvas after drawing a Universe object's bodies on a square canvas that is canvasWidth pixels x canvasWidth pixels.
 //A scaling factor is needed to make the stars big enough to see them.
 func (u *Universe) DrawToCanvas(canvas_width int, scaling_factor float64) image.Image {
+	return u.DrawToCanvasWithTrails(canvas_width, scaling_factor, nil, 0)
+}
+
+//DrawToCanvasWithTrails is DrawToCanvas with fading trails: for every tracked star still in the universe,
+//the last trail_length points of its trajectory are joined by lines that fade into the background as they get older.
+func (u *Universe) DrawToCanvasWithTrails(canvas_width int, scaling_factor float64, trajectories Trajectories, trail_length int) image.Image {
+	return u.DrawViewToCanvas(canvas_width, scaling_factor, u.FullView(), RenderStyle{}, trajectories, trail_length)
+}
+
+//DrawViewToCanvas is DrawToCanvasWithTrails showing a viewport of the universe instead of the square [0, width],
+//in one of the modes of a RenderStyle. Stars are drawn larger as the viewport zooms in,
+//so the scaling factor keeps its meaning relative to the viewport.
+func (u *Universe) DrawViewToCanvas(canvas_width int, scaling_factor float64, view Viewport, style RenderStyle, trajectories Trajectories, trail_length int) image.Image {
 	if u == nil {
 		panic("Can't Draw a nil Universe.")
 	}
@@ -37,20 +82,62 @@ func (u *Universe) DrawToCanvas(canvas_width int, scaling_factor float64) image.
 	// set a new square canvas
 	c := canvas.CreateNewCanvas(canvas_width, canvas_width)
 
-	// create a black background
-	c.SetFillColor(canvas.MakeColor(0, 0, 0))
+	// create a black background, or the lowest color of the colormap for a heatmap
+	if style.mode == "density" {
+		c.SetFillColor(canvas.MakeColor(MapColor(style.colormap, 0)))
+	} else {
+		c.SetFillColor(canvas.MakeColor(0, 0, 0))
+	}
 	c.ClearRect(0, 0, canvas_width, canvas_width)
 	c.Fill()
 
-	// range over all the bodies and draw them.
-	for _, b := range u.stars {
-		c.SetFillColor(canvas.MakeColor(b.red, b.green, b.blue))
-		cx := (b.position.x / u.width) * float64(canvas_width)
-		cy := (b.position.y / u.width) * float64(canvas_width)
-		r := scaling_factor * (b.radius / u.width) * float64(canvas_width)
-		c.Circle(cx, cy, r)
-		c.Fill()
+	// trails go below the stars
+	if trail_length > 1 {
+		for _, b := range u.stars {
+			if path, tracked := trajectories[b.id]; tracked {
+				u.DrawTrail(&c, b, path, view, canvas_width, trail_length)
+			}
+		}
+	}
+
+	switch style.mode {
+	case "density":
+		u.DrawDensity(&c, view, canvas_width, style)
+	case "velocity":
+		u.DrawVelocityField(&c, view, canvas_width, scaling_factor)
+	default:
+		// range over all the bodies and draw them.
+		for _, b := range u.stars {
+			c.SetFillColor(canvas.MakeColor(b.red, b.green, b.blue))
+			cx, cy := view.ToCanvas(b.position, canvas_width)
+			r := scaling_factor * view.Scale(b.radius, canvas_width)
+			c.Circle(cx, cy, r)
+			c.Fill()
+		}
+	}
+	if style.tree_overlay {
+		u.DrawTreeOverlay(&c, view, canvas_width)
 	}
 	// we want to return an image!
 	return c.GetImage()
 }
+
+//DrawTrail draws the end of the path of star b within a viewport in the color of the star, darker the older each segment is.
+//Segments longer than half the universe are skipped, since they only appear when a periodic box wraps the star around.
+func (u *Universe) DrawTrail(c *canvas.Canvas, b *Star, path []TrajectoryPoint, view Viewport, canvas_width, trail_length int) {
+	if len(path) > trail_length {
+		path = path[len(path)-trail_length:]
+	}
+
+	c.SetLineWidth(1)
+	for i := 1; i < len(path); i++ {
+		if Distance(path[i-1].position, path[i].position) > u.width/2 {
+			continue
+		}
+		fade := float64(i) / float64(len(path))
+		c.SetStrokeColor(canvas.MakeColor(uint8(float64(b.red)*fade), uint8(float64(b.green)*fade), uint8(float64(b.blue)*fade)))
+		c.MoveTo(view.ToCanvas(path[i-1].position, canvas_width))
+		c.LineTo(view.ToCanvas(path[i].position, canvas_width))
+		c.Stroke()
+	}
+}
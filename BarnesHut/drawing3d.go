@@ -0,0 +1,99 @@
+package main
+
+import (
+	"canvas"
+	"image"
+	"math"
+	"sort"
+)
+
+// Camera describes the direction from which a Universe3D is viewed, in degrees.
+// The azimuth rotates the view about the z axis, and the elevation is the angle above the z = 0 plane:
+// an elevation of 90 looks straight down on a galaxy disk (face-on) and an elevation of 0 sees it edge-on.
+type Camera struct {
+	azimuth   float64
+	elevation float64
+}
+
+// Project maps a point of a Universe3D of the given width to a point in the plane of the camera.
+// The center of the universe stays in the center of the view. The depth grows away from the camera.
+func (cam Camera) Project(p Triple, width float64) (OrderedPair, float64) {
+	azimuth := cam.azimuth * math.Pi / 180
+	elevation := cam.elevation * math.Pi / 180
+
+	// rotate about the z axis through the center of the universe
+	dx := p.x - width/2
+	dy := p.y - width/2
+	rx := dx*math.Cos(azimuth) - dy*math.Sin(azimuth)
+	ry := dx*math.Sin(azimuth) + dy*math.Cos(azimuth)
+
+	// tilt the view down from the z axis
+	screen := OrderedPair{x: rx + width/2, y: ry*math.Sin(elevation) + p.z*math.Cos(elevation) + width/2}
+	depth := ry*math.Cos(elevation) - p.z*math.Sin(elevation)
+
+	return screen, depth
+}
+
+// AnimateSystem3D is the three dimensional counterpart of AnimateSystem, viewing every Universe3D through a camera.
+func AnimateSystem3D(time_points []*Universe3D, canvas_width, frequency int, scaling_factor float64, camera Camera) []image.Image {
+	images := make([]image.Image, 0)
+
+	if len(time_points) == 0 {
+		panic("Error: no Universe3D objects present in AnimateSystem3D.")
+	}
+
+	for i := range time_points {
+		if i%frequency == 0 && time_points[i] != nil {
+			images = append(images, time_points[i].DrawToCanvas3D(canvas_width, scaling_factor, camera))
+		}
+	}
+
+	return images
+}
+
+// DrawingObserver3D is the three dimensional counterpart of DrawingObserver.
+func DrawingObserver3D(images *[]image.Image, canvas_width, frequency int, scaling_factor float64, camera Camera) Observer3D {
+	return func(generation int, u *Universe3D) {
+		if generation%frequency == 0 {
+			*images = append(*images, u.DrawToCanvas3D(canvas_width, scaling_factor, camera))
+		}
+	}
+}
+
+// DrawToCanvas3D projects the stars of a Universe3D through a camera and draws them on a square canvas
+// that is canvasWidth pixels x canvasWidth pixels. The farthest stars are drawn first so nearer stars cover them.
+func (u *Universe3D) DrawToCanvas3D(canvas_width int, scaling_factor float64, camera Camera) image.Image {
+	if u == nil {
+		panic("Can't Draw a nil Universe3D.")
+	}
+
+	c := canvas.CreateNewCanvas(canvas_width, canvas_width)
+
+	// create a black background
+	c.SetFillColor(canvas.MakeColor(0, 0, 0))
+	c.ClearRect(0, 0, canvas_width, canvas_width)
+	c.Fill()
+
+	positions := make([]OrderedPair, len(u.stars))
+	depths := make([]float64, len(u.stars))
+	order := make([]int, len(u.stars))
+	for i, b := range u.stars {
+		positions[i], depths[i] = camera.Project(b.position, u.width)
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return depths[order[i]] > depths[order[j]]
+	})
+
+	for _, i := range order {
+		b := u.stars[i]
+		c.SetFillColor(canvas.MakeColor(b.red, b.green, b.blue))
+		cx := (positions[i].x / u.width) * float64(canvas_width)
+		cy := (positions[i].y / u.width) * float64(canvas_width)
+		r := scaling_factor * (b.radius / u.width) * float64(canvas_width)
+		c.Circle(cx, cy, r)
+		c.Fill()
+	}
+
+	return c.GetImage()
+}
@@ -0,0 +1,137 @@
+package main
+
+import "math"
+
+// KeplerEncounter computes where the second of two point masses must be, and how fast it must move,
+// relative to the first so that they meet on a Keplerian orbit with the given pericenter and eccentricity.
+// The orbit lies in the x-y plane with its pericenter on the positive x axis, and the bodies start
+// on the incoming branch, the given separation apart.
+// Input: the sum of the two masses, the pericenter distance, an eccentricity of at least 1 (parabolic or hyperbolic)
+// and a starting separation no smaller than the pericenter.
+// Output: the position and velocity of the second body relative to the first.
+func KeplerEncounter(total_mass, pericenter, eccentricity, separation float64) (OrderedPair, OrderedPair) {
+	if eccentricity < 1 || pericenter <= 0 || separation < pericenter {
+		panic("Error: an encounter needs an eccentricity of at least 1 and a separation of at least the pericenter.")
+	}
+
+	// r = p / (1 + e cos f), with the true anomaly f negative before pericenter
+	semi_latus := pericenter * (1 + eccentricity)
+	cos_f := math.Max(-1, math.Min(1, (semi_latus/separation-1)/eccentricity))
+	f := -math.Acos(cos_f)
+
+	position := OrderedPair{separation * math.Cos(f), separation * math.Sin(f)}
+	speed := math.Sqrt(G * total_mass / semi_latus)
+	velocity := OrderedPair{-speed * math.Sin(f), speed * (eccentricity + math.Cos(f))}
+
+	return position, velocity
+}
+
+// SetupEncounter places two galaxies on a Keplerian encounter around a common center of mass at rest at center.
+// In the plane a disk can only be prograde (inclination 0) or retrograde (inclination 180), which flips its spin.
+// Input: two galaxies, the pericenter, eccentricity and starting separation of the orbit (see KeplerEncounter),
+// the inclinations of the two disks and the position of the center of mass.
+// Output: None. The positions and velocities of the stars are changed in place.
+func SetupEncounter(g0, g1 Galaxy, pericenter, eccentricity, separation float64, inclinations [2]float64, center OrderedPair) {
+	galaxies := []Galaxy{g0, g1}
+	masses := make([]float64, 2)
+	for k, g := range galaxies {
+		switch inclinations[k] {
+		case 0:
+		case 180:
+			Flip(g)
+		default:
+			panic("Error: a two dimensional disk can only have an inclination of 0 or 180 degrees.")
+		}
+		_, _, masses[k] = GalaxyCenterOfMass(g)
+	}
+
+	total_mass := masses[0] + masses[1]
+	position, velocity := KeplerEncounter(total_mass, pericenter, eccentricity, separation)
+
+	// each galaxy takes the share of the relative orbit that keeps the center of mass in place
+	for k, g := range galaxies {
+		share := masses[1-k] / total_mass
+		if k == 0 {
+			share = -share
+		}
+		MoveGalaxy(g,
+			OrderedPair{center.x + share*position.x, center.y + share*position.y},
+			OrderedPair{share * velocity.x, share * velocity.y})
+	}
+}
+
+// SetupEncounter3D is the three dimensional counterpart of SetupEncounter. The orbit lies in the x-y plane,
+// and each disk is tilted about the x axis by its inclination with Incline3D before it is placed.
+func SetupEncounter3D(g0, g1 Galaxy3D, pericenter, eccentricity, separation float64, inclinations [2]float64, center Triple) {
+	galaxies := []Galaxy3D{g0, g1}
+	masses := make([]float64, 2)
+	for k := range galaxies {
+		Incline3D(&galaxies[k], inclinations[k])
+		for _, s := range galaxies[k] {
+			masses[k] += s.mass
+		}
+	}
+
+	total_mass := masses[0] + masses[1]
+	position, velocity := KeplerEncounter(total_mass, pericenter, eccentricity, separation)
+
+	for k, g := range galaxies {
+		share := masses[1-k] / total_mass
+		if k == 0 {
+			share = -share
+		}
+		MoveGalaxy3D(g,
+			Triple{center.x + share*position.x, center.y + share*position.y, center.z},
+			Triple{share * velocity.x, share * velocity.y, 0})
+	}
+}
+
+// GalaxyCenterOfMass computes the center of mass of a galaxy, the velocity of that center and the total mass.
+func GalaxyCenterOfMass(g Galaxy) (OrderedPair, OrderedPair, float64) {
+	var com, velocity OrderedPair
+	var mass float64
+	for _, s := range g {
+		com = CalculateCOM(com, s.position, mass, s.mass)
+		velocity = CalculateCOM(velocity, s.velocity, mass, s.mass)
+		mass += s.mass
+	}
+
+	return com, velocity, mass
+}
+
+// MoveGalaxy shifts every star of a galaxy so that its center of mass is at the given position
+// and moves with the given velocity, keeping the motion of the stars relative to each other.
+func MoveGalaxy(g Galaxy, position, velocity OrderedPair) {
+	com, com_velocity, _ := GalaxyCenterOfMass(g)
+	for _, s := range g {
+		s.position.x += position.x - com.x
+		s.position.y += position.y - com.y
+		s.velocity.x += velocity.x - com_velocity.x
+		s.velocity.y += velocity.y - com_velocity.y
+	}
+}
+
+// MoveGalaxy3D is the three dimensional counterpart of MoveGalaxy.
+func MoveGalaxy3D(g Galaxy3D, position, velocity Triple) {
+	var com, com_velocity Triple
+	var mass float64
+	for _, s := range g {
+		com = CalculateCOM3D(com, s.position, mass, s.mass)
+		com_velocity = CalculateCOM3D(com_velocity, s.velocity, mass, s.mass)
+		mass += s.mass
+	}
+
+	for _, s := range g {
+		s.position = Triple{s.position.x + position.x - com.x, s.position.y + position.y - com.y, s.position.z + position.z - com.z}
+		s.velocity = Triple{s.velocity.x + velocity.x - com_velocity.x, s.velocity.y + velocity.y - com_velocity.y, s.velocity.z + velocity.z - com_velocity.z}
+	}
+}
+
+// Flip mirrors a galaxy about the horizontal line through its center of mass, which reverses its spin.
+func Flip(g Galaxy) {
+	com, com_velocity, _ := GalaxyCenterOfMass(g)
+	for _, s := range g {
+		s.position.y = 2*com.y - s.position.y
+		s.velocity.y = 2*com_velocity.y - s.velocity.y
+	}
+}
@@ -1,36 +1,108 @@
 package main
 
+import "context"
+
+// Observer is called by StreamBarnesHut once per generation with the current state of the Universe.
+// The Universe is reused for the next generation, so an observer must copy whatever it wants to keep.
+type Observer func(generation int, u *Universe)
+
 // BarnesHut is our highest level function.
-// Input: initial Universe object, a number of generations, and a time interval.
+// Input: initial Universe object, a number of generations, a time interval, a theta parameter, the name of an integrator
+// and the number of goroutines used for the force computation (NumCPU if not positive).
 // Output: collection of Universe objects corresponding to updating the system over indicated number of generations every given time interval.
-func BarnesHut(initialUniverse *Universe, num_gens int, time, theta float64) []*Universe {
+func BarnesHut(initialUniverse *Universe, num_gens int, time, theta float64, integrator string, num_procs int) []*Universe {
 	time_points := make([]*Universe, num_gens+1)
-	time_points[0] = initialUniverse
+
+	StreamBarnesHut(initialUniverse, num_gens, time, theta, integrator, num_procs, func(generation int, u *Universe) {
+		time_points[generation] = u.CopyUniverse()
+	})
+
+	return time_points
+}
+
+// StreamBarnesHut runs the same simulation as BarnesHut, but only keeps the current Universe in memory
+// and hands every generation to an observer as soon as it is computed.
+// Input: initial Universe object, a number of generations, a time interval, a theta parameter, the name of an integrator,
+// the number of goroutines used for the force computation (NumCPU if not positive) and an observer.
+// Output: the final Universe object.
+func StreamBarnesHut(initialUniverse *Universe, num_gens int, time, theta float64, integrator string, num_procs int, observer Observer) *Universe {
+	final_universe, _, _ := StreamBarnesHutContext(context.Background(), initialUniverse, num_gens, time, theta, integrator, num_procs, observer)
+
+	return final_universe
+}
+
+// StreamBarnesHutContext is StreamBarnesHut stopping early once ctx is cancelled. The context is checked between generations,
+// so the Universe it stops with has been fully updated and seen by the observer.
+// Input: a context followed by the inputs of StreamBarnesHut.
+// Output: the last Universe computed, the number of generations computed, and the error of the context if it stopped early.
+func StreamBarnesHutContext(ctx context.Context, initialUniverse *Universe, num_gens int, time, theta float64, integrator string, num_procs int, observer Observer) (*Universe, int, error) {
+	step := GetIntegrator(integrator)
+	forces := TreeForces(theta, num_procs)
+	if !initialUniverse.softening.ValidSoftening() {
+		panic("Error: invalid softening in BarnesHut.")
+	}
+	if !ValidEscapePolicy(initialUniverse.escape_policy) {
+		panic("Error: invalid escape policy in BarnesHut.")
+	}
+	if !ValidCollisionPolicy(initialUniverse.collision_policy) {
+		panic("Error: invalid collision policy in BarnesHut.")
+	}
+
+	// the integrators expect every star to start with the acceleration at its initial position
+	current_universe := initialUniverse.CopyUniverse()
+	current_universe.AssignIDs()
+	forces(current_universe, current_universe.stars)
+	observer(0, current_universe)
 
 	for i := 1; i <= num_gens; i++ {
-		time_points[i] = UpdateUniverse(time_points[i-1], time, theta)
+		if err := ctx.Err(); err != nil {
+			return current_universe, i - 1, err
+		}
+		step(current_universe, time, forces)
+		current_universe.time += time
+		// removed, reflected and merged stars change the forces, so every acceleration is out of date
+		escaped := current_universe.ApplyEscapePolicy(i)
+		merged := current_universe.ApplyCollisionPolicy(i)
+		if escaped || merged {
+			forces(current_universe, current_universe.stars)
+		}
+		observer(i, current_universe)
 	}
 
-	return time_points
+	return current_universe, num_gens, nil
 }
 
-// UpdateUniverse updates a given Universe over a specified time interval (in seconds).
-// Input: a Universe object, a float time, and a theta parameter.
-// Output: a Universe object over time seconds.
-func UpdateUniverse(current_universe *Universe, time, theta float64) *Universe {
-	new_universe := current_universe.CopyUniverse()
+// OffsetObserver creates an Observer for a run resumed from a snapshot of generation offset. It hands every generation on
+// to observer counted from the start of the original run, and skips the first one, which the original run has already seen.
+// With a zero offset, it is observer itself.
+func OffsetObserver(observer Observer, offset int) Observer {
+	if offset == 0 {
+		return observer
+	}
 
-	// constrruct quadtree
-	qt := ConstructQuadTree(new_universe.stars, new_universe.width)
-	// update the position and the mass of internal nodes (dummy stars)
-	UpdateDummyStar(qt.root)
+	return func(generation int, u *Universe) {
+		if generation != 0 {
+			observer(generation+offset, u)
+		}
+	}
+}
 
-	//range over all stars in the universe and update their acceleration, velocity, and position
-	for i := range new_universe.stars {
-		new_universe.stars[i].acceleration = new_universe.stars[i].UpdateAcceleration(qt, theta)
-		new_universe.stars[i].velocity = new_universe.stars[i].UpdateVelocity(time)
-		new_universe.stars[i].position = new_universe.stars[i].UpdatePosition(time)
+// CombineObservers creates a single Observer that calls each of the given observers in turn.
+func CombineObservers(observers ...Observer) Observer {
+	return func(generation int, u *Universe) {
+		for _, observer := range observers {
+			observer(generation, u)
+		}
 	}
+}
+
+// UpdateUniverse updates a given Universe over a specified time interval (in seconds).
+// Input: a Universe object, a float time, an integrator and a force function.
+// Output: a Universe object over time seconds.
+func UpdateUniverse(current_universe *Universe, time float64, step Integrator, forces ForceFunction) *Universe {
+	new_universe := current_universe.CopyUniverse()
+	step(new_universe, time, forces)
+	new_universe.time += time
 
 	return new_universe
 }
@@ -77,6 +149,10 @@ func (s *Star) UpdateAcceleration(qt *QuadTree, theta float64) OrderedPair {
 // Input: a quadtree and a theta parameter.
 // Output: the net force vector (OrderedPair) acting on the given star.
 func (s *Star) ComputeNetForce(qt *QuadTree, theta float64) OrderedPair {
+	if qt.period > 0 {
+		return s.ComputePeriodicNetForce(qt, theta)
+	}
+
 	var net_force OrderedPair
 	// use BFS traversal to examine each node
 	queue := make([]*Node, 1)
@@ -84,9 +160,9 @@ func (s *Star) ComputeNetForce(qt *QuadTree, theta float64) OrderedPair {
 
 	for len(queue) != 0 {
 		cur := queue[0]
-		if cur.children == nil && s.IsSameStar(cur.star) == false {
+		if cur.children == nil && !s.IsSelf(cur.star) {
 			// if the current node is a leaf node with a star
-			F := s.ComputeForce(cur.star)
+			F := s.ComputeForce(cur.star, qt.softening)
 			net_force.AddNewForce(F)
 		} else {
 			// if the current node is an internal node
@@ -98,8 +174,11 @@ func (s *Star) ComputeNetForce(qt *QuadTree, theta float64) OrderedPair {
 					}
 				}
 			} else {
-				F := s.ComputeForce(cur.star)
+				F := s.ComputeForce(cur.star, qt.softening)
 				net_force.AddNewForce(F)
+				if qt.quadrupole {
+					net_force.AddNewForce(s.ComputeQuadrupoleForce(cur))
+				}
 			}
 		}
 		queue = queue[1:]
@@ -109,18 +188,18 @@ func (s *Star) ComputeNetForce(qt *QuadTree, theta float64) OrderedPair {
 }
 
 // ComputeForce computes the force acting on star s.
-// Input: another star.
+// Input: another star and the softening to apply at short range.
 // Output: the force acting on star s.
-func (s *Star) ComputeForce(new_star *Star) OrderedPair {
+func (s *Star) ComputeForce(new_star *Star, softening Softening) OrderedPair {
 	var force OrderedPair
 
 	d := Distance(s.position, new_star.position)
-	F := G * s.mass * new_star.mass / (d * d)
+	F := G * s.mass * new_star.mass * softening.ForceFactor(d)
 	deltaX := new_star.position.x - s.position.x
 	deltaY := new_star.position.y - s.position.y
 
-	force.x = F * deltaX / d
-	force.y = F * deltaY / d
+	force.x = F * deltaX
+	force.y = F * deltaY
 
 	return force
 }
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"math"
+	"runtime"
+)
+
+// Observer3D is called by StreamBarnesHut3D once per generation with the current state of the Universe3D.
+// The Universe3D is reused for the next generation, so an observer must copy whatever it wants to keep.
+type Observer3D func(generation int, u *Universe3D)
+
+// Integrator3D advances every star of a Universe3D by a single time step, in the same way as Integrator.
+type Integrator3D func(u *Universe3D, time float64, forces ForceFunction3D)
+
+// ForceFunction3D sets the acceleration of every star in a Universe3D from the current positions.
+type ForceFunction3D func(u *Universe3D)
+
+// BarnesHut3D is the three dimensional counterpart of BarnesHut.
+// Input: initial Universe3D object, a number of generations, a time interval, a theta parameter, the name of an integrator
+// and the number of goroutines used for the force computation (NumCPU if not positive).
+// Output: collection of Universe3D objects, one for every generation.
+func BarnesHut3D(initialUniverse *Universe3D, num_gens int, time, theta float64, integrator string, num_procs int) []*Universe3D {
+	time_points := make([]*Universe3D, num_gens+1)
+
+	StreamBarnesHut3D(initialUniverse, num_gens, time, theta, integrator, num_procs, func(generation int, u *Universe3D) {
+		time_points[generation] = u.CopyUniverse3D()
+	})
+
+	return time_points
+}
+
+// StreamBarnesHut3D is the three dimensional counterpart of StreamBarnesHut.
+// Input: initial Universe3D object, a number of generations, a time interval, a theta parameter, the name of an integrator,
+// the number of goroutines used for the force computation (NumCPU if not positive) and an observer.
+// Output: None.
+func StreamBarnesHut3D(initialUniverse *Universe3D, num_gens int, time, theta float64, integrator string, num_procs int, observer Observer3D) {
+	StreamBarnesHut3DContext(context.Background(), initialUniverse, num_gens, time, theta, integrator, num_procs, observer)
+}
+
+// StreamBarnesHut3DContext is the three dimensional counterpart of StreamBarnesHutContext.
+// Output: the number of generations computed, and the error of the context if it stopped early.
+func StreamBarnesHut3DContext(ctx context.Context, initialUniverse *Universe3D, num_gens int, time, theta float64, integrator string, num_procs int, observer Observer3D) (int, error) {
+	step := GetIntegrator3D(integrator)
+	forces := TreeForces3D(theta, num_procs)
+	if !initialUniverse.softening.ValidSoftening() {
+		panic("Error: invalid softening in BarnesHut3D.")
+	}
+
+	// the integrators expect every star to start with the acceleration at its initial position
+	current_universe := initialUniverse.CopyUniverse3D()
+	forces(current_universe)
+	observer(0, current_universe)
+
+	for i := 1; i <= num_gens; i++ {
+		if err := ctx.Err(); err != nil {
+			return i - 1, err
+		}
+		step(current_universe, time, forces)
+		observer(i, current_universe)
+	}
+
+	return num_gens, nil
+}
+
+// ValidIntegrator3D checks whether a three dimensional integration scheme with the given name exists.
+// The adaptive and block schemes of the plane have no three dimensional counterpart.
+func ValidIntegrator3D(name string) bool {
+	switch name {
+	case "euler", "leapfrog", "verlet":
+		return true
+	}
+
+	return false
+}
+
+// GetIntegrator3D looks up a three dimensional integration scheme by name.
+// Input: the name of the scheme ("euler", "leapfrog" or "verlet").
+// Output: the corresponding Integrator3D.
+func GetIntegrator3D(name string) Integrator3D {
+	switch name {
+	case "euler":
+		return EulerStep3D
+	case "leapfrog":
+		return LeapfrogStep3D
+	case "verlet":
+		return VerletStep3D
+	}
+	panic("Error: unknown integrator " + name + ".")
+}
+
+// EulerStep3D is the three dimensional counterpart of EulerStep.
+func EulerStep3D(u *Universe3D, time float64, forces ForceFunction3D) {
+	forces(u)
+	for _, s := range u.stars {
+		s.velocity.x += s.acceleration.x * time
+		s.velocity.y += s.acceleration.y * time
+		s.velocity.z += s.acceleration.z * time
+		s.position.x += s.velocity.x*time + s.acceleration.x*time*time/2
+		s.position.y += s.velocity.y*time + s.acceleration.y*time*time/2
+		s.position.z += s.velocity.z*time + s.acceleration.z*time*time/2
+	}
+}
+
+// LeapfrogStep3D is the three dimensional counterpart of LeapfrogStep.
+func LeapfrogStep3D(u *Universe3D, time float64, forces ForceFunction3D) {
+	for _, s := range u.stars {
+		s.Kick(time / 2)
+		s.position.x += s.velocity.x * time
+		s.position.y += s.velocity.y * time
+		s.position.z += s.velocity.z * time
+	}
+	forces(u)
+	for _, s := range u.stars {
+		s.Kick(time / 2)
+	}
+}
+
+// VerletStep3D is the three dimensional counterpart of VerletStep.
+func VerletStep3D(u *Universe3D, time float64, forces ForceFunction3D) {
+	old_accelerations := make([]Triple, len(u.stars))
+	for i, s := range u.stars {
+		old_accelerations[i] = s.acceleration
+		s.position.x += s.velocity.x*time + s.acceleration.x*time*time/2
+		s.position.y += s.velocity.y*time + s.acceleration.y*time*time/2
+		s.position.z += s.velocity.z*time + s.acceleration.z*time*time/2
+	}
+	forces(u)
+	for i, s := range u.stars {
+		s.velocity.x += (old_accelerations[i].x + s.acceleration.x) * time / 2
+		s.velocity.y += (old_accelerations[i].y + s.acceleration.y) * time / 2
+		s.velocity.z += (old_accelerations[i].z + s.acceleration.z) * time / 2
+	}
+}
+
+// Kick updates the velocity of a star using its current acceleration over a time interval.
+func (s *Star3D) Kick(time float64) {
+	s.velocity.x += s.acceleration.x * time
+	s.velocity.y += s.acceleration.y * time
+	s.velocity.z += s.acceleration.z * time
+}
+
+// TreeForces3D creates a ForceFunction3D that uses the Barnes-Hut octree.
+// Input: a theta parameter and the number of goroutines to spread the stars over (NumCPU if not positive).
+// Output: the corresponding ForceFunction3D.
+func TreeForces3D(theta float64, num_procs int) ForceFunction3D {
+	if num_procs <= 0 {
+		num_procs = runtime.NumCPU()
+	}
+
+	return func(u *Universe3D) {
+		u.UpdateAccelerations3D(theta, num_procs)
+	}
+}
+
+// UpdateAccelerations3D constructs an octree from the current positions of the stars and
+// sets the acceleration of every star in the universe from it.
+// Input: a Universe3D object, a theta parameter and the number of goroutines to use.
+// Output: None.
+func (u *Universe3D) UpdateAccelerations3D(theta float64, num_procs int) {
+	ot := u.BuildOctTree()
+
+	accelerations := make([]Triple, len(u.stars))
+	SplitAmongProcs(len(u.stars), num_procs, func(start, end int) {
+		for i := start; i < end; i++ {
+			force := u.stars[i].ComputeNetForce3D(ot, theta)
+			accelerations[i] = Triple{force.x / u.stars[i].mass, force.y / u.stars[i].mass, force.z / u.stars[i].mass}
+		}
+	})
+
+	for i := range u.stars {
+		u.stars[i].acceleration = accelerations[i]
+	}
+}
+
+// ComputeNetForce3D sums all forces based on the octree acting on the star s.
+// Input: an octree and a theta parameter.
+// Output: the net force vector (Triple) acting on the given star.
+func (s *Star3D) ComputeNetForce3D(ot *OctTree, theta float64) Triple {
+	var net_force Triple
+	// use a stack to examine each node
+	stack := make([]*OctNode, 1, 64)
+	stack[0] = ot.root
+
+	for len(stack) != 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur.children == nil {
+			// a leaf node with a star, which is skipped if it is s itself
+			if cur.star != s {
+				net_force.AddNewForce3D(s.ComputeForce3D(cur.star, ot.softening))
+			}
+		} else if s.CalculateTheta3D(cur) > theta {
+			// an internal node that is too close: open it
+			for i := range cur.children {
+				if cur.children[i].star != nil {
+					stack = append(stack, cur.children[i])
+				}
+			}
+		} else {
+			// an internal node that is far enough away: use its dummy star
+			net_force.AddNewForce3D(s.ComputeForce3D(cur.star, ot.softening))
+		}
+	}
+
+	return net_force
+}
+
+// ComputeForce3D computes the softened force acting on star s due to another star.
+// Input: another star and the softening to apply at short range.
+// Output: the force acting on star s.
+func (s *Star3D) ComputeForce3D(new_star *Star3D, softening Softening) Triple {
+	d := Distance3D(s.position, new_star.position)
+	F := G * s.mass * new_star.mass * softening.ForceFactor(d)
+
+	return Triple{
+		F * (new_star.position.x - s.position.x),
+		F * (new_star.position.y - s.position.y),
+		F * (new_star.position.z - s.position.z),
+	}
+}
+
+// CalculateTheta3D computes the ratio of the width of a node to its distance from star s.
+func (s *Star3D) CalculateTheta3D(node *OctNode) float64 {
+	return node.sector.width / Distance3D(s.position, node.star.position)
+}
+
+// AddNewForce3D sums two forces.
+func (total_force *Triple) AddNewForce3D(new_force Triple) {
+	total_force.x += new_force.x
+	total_force.y += new_force.y
+	total_force.z += new_force.z
+}
+
+// Distance3D calculates the distance between two points in three dimensions.
+func Distance3D(p1, p2 Triple) float64 {
+	deltaX := p1.x - p2.x
+	deltaY := p1.y - p2.y
+	deltaZ := p1.z - p2.z
+	return math.Sqrt(deltaX*deltaX + deltaY*deltaY + deltaZ*deltaZ)
+}
+
+// CopyStar3D makes a deep copy of a three dimensional star.
+func (current_star *Star3D) CopyStar3D() *Star3D {
+	new_star := *current_star
+
+	return &new_star
+}
+
+// CopyUniverse3D makes a deep copy of a Universe3D.
+func (current_universe *Universe3D) CopyUniverse3D() *Universe3D {
+	var new_universe Universe3D
+	new_universe.width = current_universe.width
+	new_universe.softening = current_universe.softening
+	new_universe.stars = make([]*Star3D, len(current_universe.stars))
+	for i := range new_universe.stars {
+		new_universe.stars[i] = current_universe.stars[i].CopyStar3D()
+	}
+
+	return &new_universe
+}
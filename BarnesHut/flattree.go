@@ -0,0 +1,272 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// morton_levels is the depth of the FlatTree: each Morton key holds this many bits of each coordinate.
+// Stars that still share a cell at the deepest level end up together in one leaf.
+const morton_levels = 31
+
+// FlatTree is a compact quadtree stored in a single slice of nodes. The stars are sorted by their Morton key,
+// so every node covers a contiguous run of them, and the children of a node are stored next to each other.
+// A FlatTree can be rebuilt every generation into the same slices, so it stops allocating once they are large enough.
+type FlatTree struct {
+	nodes         []FlatNode
+	stars         []*Star
+	keys          []uint64
+	accelerations []OrderedPair
+	softening     Softening
+	quadrupole    bool
+	period        float64
+}
+
+// FlatNode is a node of a FlatTree. It holds its own center of mass instead of a dummy star, and refers
+// to its stars and children by index. A leaf has no children and interacts through its stars one by one.
+type FlatNode struct {
+	com          OrderedPair
+	mass         float64
+	width        float64
+	quadrupole   Quadrupole
+	first, count int32 // the stars of the node are stars[first : first+count]
+	child        int32 // index of the first child
+	num_children int32
+}
+
+// BuildFlatTree creates a new FlatTree from the stars of a Universe.
+func (u *Universe) BuildFlatTree() *FlatTree {
+	var ft FlatTree
+	ft.Rebuild(u)
+
+	return &ft
+}
+
+// Rebuild sorts the stars of a universe along the Morton curve of their bounding sector and rebuilds the tree
+// and its moments in place, reusing the memory of the previous build.
+// Input: a FlatTree and a Universe object.
+// Output: None.
+func (ft *FlatTree) Rebuild(u *Universe) {
+	ft.softening = u.softening
+	ft.quadrupole = u.quadrupole
+	ft.period = 0
+	if u.Periodic() {
+		ft.period = u.width
+	}
+
+	ft.stars = append(ft.stars[:0], u.stars...)
+	ft.keys = ft.keys[:0]
+	sector := u.BoundingSector()
+	for _, s := range ft.stars {
+		ft.keys = append(ft.keys, MortonKey(s.position, sector))
+	}
+	sort.Sort(ft)
+
+	ft.nodes = ft.nodes[:0]
+	if len(ft.stars) == 0 {
+		return
+	}
+	ft.nodes = append(ft.nodes, FlatNode{})
+	ft.Split(0, 0, int32(len(ft.stars)), 0, sector.width)
+	ft.UpdateMoments()
+}
+
+// Len, Less and Swap sort the stars of a FlatTree together with their keys.
+func (ft *FlatTree) Len() int           { return len(ft.stars) }
+func (ft *FlatTree) Less(i, j int) bool { return ft.keys[i] < ft.keys[j] }
+func (ft *FlatTree) Swap(i, j int) {
+	ft.keys[i], ft.keys[j] = ft.keys[j], ft.keys[i]
+	ft.stars[i], ft.stars[j] = ft.stars[j], ft.stars[i]
+}
+
+// MortonKey interleaves the bits of the coordinates of a point within a sector, x in the even bits and y in the odd bits,
+// so that sorting by key visits the quadrants of every node in turn.
+func MortonKey(p OrderedPair, sector Quadrant) uint64 {
+	cells := float64(uint64(1) << morton_levels)
+	ix := uint64(math.Max(0, math.Min(cells-1, (p.x-sector.x)/sector.width*cells)))
+	iy := uint64(math.Max(0, math.Min(cells-1, (p.y-(sector.y-sector.width))/sector.width*cells)))
+
+	return Spread(ix) | Spread(iy)<<1
+}
+
+// Spread moves the bit i of a 32 bit number to bit 2i.
+func Spread(v uint64) uint64 {
+	v &= 0xffffffff
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+
+	return v
+}
+
+// Split turns the node at index into a leaf or an internal node covering the stars [first, first+count).
+// The stars of an internal node are divided among its children by the two bits of their keys for the next level,
+// and the non-empty children are appended next to each other before each of them is split in turn.
+// Input: the index of the node, its stars, its level and the width of its sector.
+// Output: None.
+func (ft *FlatTree) Split(index, first, count int32, level uint, width float64) {
+	ft.nodes[index] = FlatNode{width: width, first: first, count: count}
+	if count == 1 || level == morton_levels {
+		return
+	}
+
+	shift := 2 * (morton_levels - 1 - level)
+	ends := [4]int32{}
+	start := first
+	for quadrant := range ends {
+		end := start
+		for end < first+count && int((ft.keys[end]>>shift)&3) == quadrant {
+			end++
+		}
+		ends[quadrant] = end
+		start = end
+	}
+
+	child := int32(len(ft.nodes))
+	start = first
+	for _, end := range ends {
+		if end > start {
+			ft.nodes = append(ft.nodes, FlatNode{})
+		}
+		start = end
+	}
+	ft.nodes[index].child = child
+	ft.nodes[index].num_children = int32(len(ft.nodes)) - child
+
+	start = first
+	for _, end := range ends {
+		if end > start {
+			ft.Split(child, start, end-start, level+1, width/2)
+			child++
+		}
+		start = end
+	}
+}
+
+// UpdateMoments computes the mass, center of mass and (if asked for) quadrupole moment of every node.
+// Children always come after their parent in the slice, so going backwards visits the children first.
+func (ft *FlatTree) UpdateMoments() {
+	for i := len(ft.nodes) - 1; i >= 0; i-- {
+		n := &ft.nodes[i]
+		n.com, n.mass, n.quadrupole = OrderedPair{}, 0, Quadrupole{}
+
+		if n.num_children == 0 {
+			for _, s := range ft.stars[n.first : n.first+n.count] {
+				n.com = CalculateCOM(n.com, s.position, n.mass, s.mass)
+				n.mass += s.mass
+			}
+			if ft.quadrupole {
+				for _, s := range ft.stars[n.first : n.first+n.count] {
+					n.quadrupole.AddPoint(s.position.x-n.com.x, s.position.y-n.com.y, s.mass, Quadrupole{})
+				}
+			}
+			continue
+		}
+
+		children := ft.nodes[n.child : n.child+n.num_children]
+		for _, c := range children {
+			n.com = CalculateCOM(n.com, c.com, n.mass, c.mass)
+			n.mass += c.mass
+		}
+		if ft.quadrupole {
+			for _, c := range children {
+				n.quadrupole.AddPoint(c.com.x-n.com.x, c.com.y-n.com.y, c.mass, c.quadrupole)
+			}
+		}
+	}
+}
+
+// UpdateAccelerations sets the acceleration of the active stars by walking the tree, in parallel if num_procs > 1.
+// Input: a built FlatTree, the stars to update, a theta parameter and the number of goroutines to use.
+// Output: None.
+func (ft *FlatTree) UpdateAccelerations(active []*Star, theta float64, num_procs int) {
+	if cap(ft.accelerations) < len(active) {
+		ft.accelerations = make([]OrderedPair, len(active))
+	}
+	accelerations := ft.accelerations[:len(active)]
+
+	// every acceleration is computed before any star is moved, so that the order of the stars does not matter
+	if num_procs <= 1 {
+		AccelerationsSingleproc(active, ft, theta, accelerations)
+	} else {
+		AccelerationsMultiprocs(active, ft, theta, accelerations, num_procs)
+	}
+
+	for i := range active {
+		active[i].acceleration = accelerations[i]
+	}
+}
+
+// NetForce sums the forces of the tree on star s. The walk keeps the nodes still to visit on a fixed-size stack,
+// so it does not allocate. A node is used as a whole when its width divided by its distance to s is at most theta.
+// Input: a built FlatTree, a star and a theta parameter.
+// Output: the net force vector acting on the given star.
+func (ft *FlatTree) NetForce(s *Star, theta float64) OrderedPair {
+	var net_force OrderedPair
+	if len(ft.nodes) == 0 {
+		return net_force
+	}
+
+	// each visit replaces one node by at most four, so the stack never holds more than 3 per level;
+	// the walk starts from the root, which is node 0
+	var stack [3*morton_levels + 4]int32
+	top := 1
+
+	for top > 0 {
+		top--
+		n := &ft.nodes[stack[top]]
+
+		if n.num_children == 0 {
+			for _, other := range ft.stars[n.first : n.first+n.count] {
+				if !s.IsSelf(other) {
+					net_force.AddNewForce(ft.PointForce(s, other.position, other.mass, nil))
+				}
+			}
+			continue
+		}
+
+		d := Distance(s.position, n.com)
+		if ft.period > 0 {
+			image := NearestImage(s.position, n.com, ft.period)
+			d = math.Hypot(image.x, image.y)
+		}
+		if n.width/d > theta || (ft.period > 0 && n.width > ft.period/4) {
+			for c := n.child; c < n.child+n.num_children; c++ {
+				stack[top] = c
+				top++
+			}
+		} else if ft.quadrupole {
+			net_force.AddNewForce(ft.PointForce(s, n.com, n.mass, &n.quadrupole))
+		} else {
+			net_force.AddNewForce(ft.PointForce(s, n.com, n.mass, nil))
+		}
+	}
+
+	return net_force
+}
+
+// PointForce computes the force on star s of a mass at a position, with an optional quadrupole moment about it.
+// In a periodic box the mass is seen at its nearest image, and the Ewald correction adds all of its other images.
+func (ft *FlatTree) PointForce(s *Star, position OrderedPair, mass float64, quadrupole *Quadrupole) OrderedPair {
+	body := Star{position: position, mass: mass}
+	target := s
+	var correction OrderedPair
+	if ft.period > 0 {
+		d := NearestImage(s.position, position, ft.period)
+		image := *s
+		image.position = OrderedPair{position.x - d.x, position.y - d.y}
+		target = &image
+		c := EwaldCorrection(d, ft.period)
+		correction = OrderedPair{G * s.mass * mass * c.x, G * s.mass * mass * c.y}
+	}
+
+	force := target.ComputeForce(&body, ft.softening)
+	force.AddNewForce(correction)
+	if quadrupole != nil {
+		force.AddNewForce(target.QuadrupoleForce(position, *quadrupole))
+	}
+
+	return force
+}
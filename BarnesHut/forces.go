@@ -0,0 +1,82 @@
+package main
+
+import "runtime"
+
+// ForceFunction sets the acceleration of the active stars in a Universe from the current positions of all of its stars.
+// Passing u.stars as the active stars updates every star.
+type ForceFunction func(u *Universe, active []*Star)
+
+// TreeForces creates a ForceFunction that uses the Barnes-Hut quadtree.
+// The same FlatTree is rebuilt for every call, so a running simulation does not allocate a new tree every generation.
+// Input: a theta parameter and the number of goroutines to spread the stars over (NumCPU if not positive).
+// Output: the corresponding ForceFunction.
+func TreeForces(theta float64, num_procs int) ForceFunction {
+	if num_procs <= 0 {
+		num_procs = runtime.NumCPU()
+	}
+	var ft FlatTree
+
+	return func(u *Universe, active []*Star) {
+		ft.Rebuild(u)
+		ft.UpdateAccelerations(active, theta, num_procs)
+	}
+}
+
+// UpdateAccelerations constructs a quadtree from the current positions of all stars in the universe and
+// sets the acceleration of the active stars from it.
+// Input: a Universe object, the stars to update, a theta parameter and the number of goroutines to use.
+// Output: None.
+func (u *Universe) UpdateAccelerations(active []*Star, theta float64, num_procs int) {
+	u.BuildFlatTree().UpdateAccelerations(active, theta, num_procs)
+}
+
+// AccelerationsSingleproc computes the acceleration of every star in a slice by walking the tree.
+// Input: a slice of stars, a read-only tree, a theta parameter and a slice of the same length to store the results.
+// Output: None.
+func AccelerationsSingleproc(stars []*Star, ft *FlatTree, theta float64, accelerations []OrderedPair) {
+	for i, s := range stars {
+		force := ft.NetForce(s, theta)
+		accelerations[i] = OrderedPair{force.x / s.mass, force.y / s.mass}
+	}
+}
+
+// AccelerationsMultiprocs computes the acceleration of every star in parallel.
+// Each goroutine writes only to its own piece of the results, so the outcome is identical to AccelerationsSingleproc.
+// Input: a slice of stars, a read-only tree, a theta parameter, a slice to store the results and the number of goroutines.
+// Output: None.
+func AccelerationsMultiprocs(stars []*Star, ft *FlatTree, theta float64, accelerations []OrderedPair, num_procs int) {
+	SplitAmongProcs(len(stars), num_procs, func(start, end int) {
+		AccelerationsSingleproc(stars[start:end], ft, theta, accelerations[start:end])
+	})
+}
+
+// SplitAmongProcs splits the indices 0 to num_items into num_procs approximately equal pieces,
+// runs work on every piece in its own goroutine and waits for all of them to finish.
+// Input: the number of items, the number of goroutines and the work to do on the piece [start, end).
+// Output: None.
+func SplitAmongProcs(num_items, num_procs int, work func(start, end int)) {
+	if num_procs > num_items {
+		num_procs = num_items
+	}
+	if num_procs <= 1 {
+		work(0, num_items)
+		return
+	}
+	c := make(chan bool, num_procs)
+
+	for i := 0; i < num_procs; i++ {
+		start_index := i * (num_items / num_procs)
+		end_index := (i + 1) * (num_items / num_procs)
+		if i == num_procs-1 {
+			end_index = num_items
+		}
+		go func(start, end int) {
+			work(start, end)
+			c <- true
+		}(start_index, end_index)
+	}
+
+	for i := 0; i < num_procs; i++ {
+		<-c
+	}
+}
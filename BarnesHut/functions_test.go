@@ -1,8 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConstructQuadTree(t *testing.T) {
@@ -19,7 +34,7 @@ func TestUpdateVelocity(t *testing.T) {
 		answer OrderedPair
 	}
 
-	var s = Star{OrderedPair{100, 100}, OrderedPair{2, 4}, OrderedPair{1, 0}, 1, 1, 0, 0, 0}
+	var s = Star{position: OrderedPair{100, 100}, velocity: OrderedPair{2, 4}, acceleration: OrderedPair{1, 0}, mass: 1, radius: 1}
 	time := 1.0
 	var ans = OrderedPair{3, 4}
 	var test_case = test{s, time, ans}
@@ -39,7 +54,7 @@ func TestUpdatePosition(t *testing.T) {
 		answer OrderedPair
 	}
 
-	var s = Star{OrderedPair{100, 100}, OrderedPair{2, 4}, OrderedPair{1, 0}, 1, 1, 0, 0, 0}
+	var s = Star{position: OrderedPair{100, 100}, velocity: OrderedPair{2, 4}, acceleration: OrderedPair{1, 0}, mass: 1, radius: 1}
 	time := 1.0
 	var ans = OrderedPair{102.5, 104.0}
 	var test_case = test{s, time, ans}
@@ -60,7 +75,7 @@ func TestInWhich(t *testing.T) {
 	}
 
 	var p = OrderedPair{76, 80}
-	var n = Node{nil, nil, Quadrant{0, 100, 100}}
+	var n = Node{sector: Quadrant{0, 100, 100}}
 	ans := 3
 	var test_case = test{p, n, ans}
 
@@ -125,5 +140,1453 @@ func CreateCustomUniverse() *Universe {
 }
 
 func (u *Universe) AddStar(s Star) {
+	s.id = len(u.stars) + 1
 	u.stars = append(u.stars, &s)
 }
+
+func TestLeapfrogStep(t *testing.T) {
+	type test struct {
+		u         *Universe
+		num_steps int
+		time      float64
+		tolerance float64
+		answer    float64
+	}
+
+	// a light star on a circular orbit of radius 1e7 around a heavy one
+	u := CreateOrbitUniverse()
+	r := 1.0e7
+	period := 2 * math.Pi * math.Sqrt(r*r*r/(G*u.stars[0].mass))
+	var test_case = test{u, 1000, period / 1000, 0.001, r}
+
+	forces := TreeForces(0.5, 1)
+	forces(test_case.u, test_case.u.stars)
+	for i := 0; i < test_case.num_steps; i++ {
+		LeapfrogStep(test_case.u, test_case.time, forces)
+	}
+	outcome := Distance(test_case.u.stars[0].position, test_case.u.stars[1].position)
+	if math.Abs(outcome-test_case.answer)/test_case.answer > test_case.tolerance {
+		t.Errorf("Error! Output: (%f) but the answer is: (%f)", outcome, test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func CreateOrbitUniverse() *Universe {
+	var A, B Star
+	A.position.x, A.position.y = 2e7, 2e7
+	A.mass = 1e24
+	B.position.x, B.position.y = 3e7, 2e7
+	B.mass = 1
+	B.velocity.y = math.Sqrt(G * A.mass / 1e7)
+
+	var orbit_universe Universe
+	orbit_universe.width = 4e7
+	orbit_universe.AddStar(A)
+	orbit_universe.AddStar(B)
+
+	return &orbit_universe
+}
+
+func TestPotentialEnergy(t *testing.T) {
+	type test struct {
+		u      *Universe
+		theta  float64
+		answer float64
+	}
+
+	u := CreateOrbitUniverse()
+	ans := -G * u.stars[0].mass * u.stars[1].mass / 1e7
+	var test_case = test{u, 0.5, ans}
+
+	exact := test_case.u.PotentialEnergy()
+	tree := test_case.u.TreePotentialEnergy(test_case.theta)
+	if math.Abs(exact-test_case.answer) > 1e-9*math.Abs(test_case.answer) || math.Abs(tree-test_case.answer) > 1e-9*math.Abs(test_case.answer) {
+		t.Errorf("Error! Output: (%e, %e) but the answer is: (%e)", exact, tree, test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestComputeForce(t *testing.T) {
+	type test struct {
+		s1, s2    Star
+		softening Softening
+		answer    OrderedPair
+	}
+
+	// coincident stars must not produce NaN once gravity is softened
+	var s1 = Star{position: OrderedPair{5, 5}, mass: 1}
+	var s2 = Star{position: OrderedPair{5, 5}, mass: 1}
+	test_cases := []test{
+		{s1, s2, Softening{"plummer", 1}, OrderedPair{0, 0}},
+		{s1, s2, Softening{"spline", 1}, OrderedPair{0, 0}},
+	}
+
+	for _, test_case := range test_cases {
+		outcome := test_case.s1.ComputeForce(&test_case.s2, test_case.softening)
+		if outcome != test_case.answer {
+			t.Errorf("Error! Output: (%f, %f) but the answer is: (%f, %f)", outcome.x, outcome.y, test_case.answer.x, test_case.answer.y)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestCoincidentNetForce(t *testing.T) {
+	type test struct {
+		u      *Universe
+		theta  float64
+		answer OrderedPair
+	}
+
+	// coincident stars must go through the tree walk without hanging or producing NaN
+	u := &Universe{width: 100, softening: Softening{"plummer", 1}}
+	u.AddStar(Star{position: OrderedPair{10, 10}, mass: 1})
+	u.AddStar(Star{position: OrderedPair{10, 10}, mass: 1})
+	u.AddStar(Star{position: OrderedPair{50, 50}, mass: 1})
+	// only the distant star pulls on a star at the shared position
+	answer := u.stars[0].ComputeForce(u.stars[2], u.softening)
+	var test_case = test{u, 0, answer}
+
+	outcome := test_case.u.stars[0].ComputeNetForce(test_case.u.BuildQuadTree(), test_case.theta)
+	if math.Abs(outcome.x-test_case.answer.x) > 1e-12*math.Abs(test_case.answer.x) || math.Abs(outcome.y-test_case.answer.y) > 1e-12*math.Abs(test_case.answer.y) {
+		t.Errorf("Error! Output: (%e, %e) but the answer is: (%e, %e)", outcome.x, outcome.y, test_case.answer.x, test_case.answer.y)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestSofteningContinuity(t *testing.T) {
+	// both kernels must join Newtonian gravity smoothly
+	soft := Softening{"spline", 1}
+	for _, d := range []float64{1.4, 2.8} {
+		below := soft.ForceFactor(d * (1 - 1e-9))
+		above := soft.ForceFactor(d * (1 + 1e-9))
+		if math.Abs(below-above)/above > 1e-6 {
+			t.Errorf("Error! Force factor jumps from %f to %f at %f", below, above, d)
+		}
+		below = soft.PotentialFactor(d * (1 - 1e-9))
+		above = soft.PotentialFactor(d * (1 + 1e-9))
+		if math.Abs(below-above)/above > 1e-6 {
+			t.Errorf("Error! Potential factor jumps from %f to %f at %f", below, above, d)
+		}
+	}
+}
+
+func TestAccelerationsMultiprocs(t *testing.T) {
+	type test struct {
+		u         *Universe
+		theta     float64
+		num_procs int
+	}
+
+	u := CreateCollisionUniverse()
+	var test_case = test{u, 0.5, 4}
+
+	serial := test_case.u.CopyUniverse()
+	parallel := test_case.u.CopyUniverse()
+	serial.UpdateAccelerations(serial.stars, test_case.theta, 1)
+	parallel.UpdateAccelerations(parallel.stars, test_case.theta, test_case.num_procs)
+	for i := range serial.stars {
+		if serial.stars[i].acceleration != parallel.stars[i].acceleration {
+			t.Errorf("Error! Star %d has acceleration (%e, %e) in parallel but (%e, %e) in serial", i, parallel.stars[i].acceleration.x, parallel.stars[i].acceleration.y, serial.stars[i].acceleration.x, serial.stars[i].acceleration.y)
+			return
+		}
+	}
+	fmt.Println("Pass!")
+}
+
+func BenchmarkAccelerationsSerial(b *testing.B) {
+	u := CreateCollisionUniverse()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u.UpdateAccelerations(u.stars, 0.5, 1)
+	}
+}
+
+func BenchmarkAccelerationsParallel(b *testing.B) {
+	u := CreateCollisionUniverse()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u.UpdateAccelerations(u.stars, 0.5, runtime.NumCPU())
+	}
+}
+
+// BenchmarkForces measures one force computation of the pointer quadtree and of the FlatTree reused by TreeForces,
+// reporting allocations and the time per star.
+func BenchmarkForces(b *testing.B) {
+	for _, num_stars := range []int{10000, 100000} {
+		u := CreateClusterUniverse(num_stars)
+
+		b.Run(fmt.Sprintf("QuadTree/%d", num_stars), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				qt := u.BuildQuadTree()
+				for _, s := range u.stars {
+					s.acceleration = s.UpdateAcceleration(qt, 0.5)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*num_stars), "ns/star")
+		})
+
+		b.Run(fmt.Sprintf("FlatTree/%d", num_stars), func(b *testing.B) {
+			forces := TreeForces(0.5, 1)
+			forces(u, u.stars)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				forces(u, u.stars)
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*num_stars), "ns/star")
+		})
+	}
+}
+
+// CreateCollisionUniverse builds the 1000-star scenario of CollisionSimulation.
+func CreateCollisionUniverse() *Universe {
+	rng := rand.New(rand.NewSource(1))
+	g0 := InitializeGalaxy(rng, 500, 4e21, 5e22, 4e22)
+	g1 := InitializeGalaxy(rng, 500, 4e21, 4e22, 4e22)
+	Push(&g0, OrderedPair{-100, 200})
+	Push(&g1, OrderedPair{200, -100})
+
+	u := InitializeUniverse([]Galaxy{g0, g1}, 1.0e23)
+	u.softening = Softening{kernel: "plummer", length: 1e20}
+
+	return u
+}
+
+func TestStreamBarnesHut(t *testing.T) {
+	type test struct {
+		u        *Universe
+		num_gens int
+		time     float64
+	}
+
+	var test_case = test{CreateOrbitUniverse(), 10, 100}
+
+	time_points := BarnesHut(test_case.u, test_case.num_gens, test_case.time, 0.5, "leapfrog", 1)
+	var last *Universe
+	count := 0
+	StreamBarnesHut(test_case.u, test_case.num_gens, test_case.time, 0.5, "leapfrog", 1, func(generation int, u *Universe) {
+		count++
+		last = u
+	})
+
+	if count != test_case.num_gens+1 || last.stars[1].position != time_points[test_case.num_gens].stars[1].position {
+		t.Errorf("Error! Streaming observed %d generations ending at (%f, %f)", count, last.stars[1].position.x, last.stars[1].position.y)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestInWhichOctant(t *testing.T) {
+	type test struct {
+		p      Triple
+		n      *OctNode
+		answer int
+	}
+
+	var n = &OctNode{nil, nil, Octant{0, 0, -50, 100}}
+	test_cases := []test{
+		{Triple{10, 10, -10}, n, 0},
+		{Triple{76, 80, 20}, n, 7},
+		{Triple{76, 10, -10}, n, 1},
+		{Triple{10, 80, 20}, n, 6},
+	}
+
+	for _, test_case := range test_cases {
+		outcome := InWhichOctant(test_case.p, test_case.n)
+		if outcome != test_case.answer {
+			t.Errorf("Error! Output: (%d) but the answer is: (%d)", outcome, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestUpdateDummyStar3D(t *testing.T) {
+	type test struct {
+		stars  []*Star3D
+		width  float64
+		answer Triple
+	}
+
+	stars := []*Star3D{
+		{position: Triple{1, 1, -1}, mass: 1},
+		{position: Triple{2, 1, -1}, mass: 1},
+		{position: Triple{15, 15, 7}, mass: 2},
+	}
+	var test_case = test{stars, 16, Triple{8.25, 8, 3}}
+
+	ot := ConstructOctTree(test_case.stars, test_case.width)
+	position, mass := UpdateDummyStar3D(ot.root)
+	if Distance3D(position, test_case.answer) > 1e-12 || mass != 4 {
+		t.Errorf("Error! Output: (%f, %f, %f) but the answer is: (%f, %f, %f)", position.x, position.y, position.z, test_case.answer.x, test_case.answer.y, test_case.answer.z)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestConstructOctTree(t *testing.T) {
+	type test struct {
+		stars  []*Star3D
+		width  float64
+		answer float64
+	}
+
+	// two stars beyond the same corner of the universe used to split forever, and so did two stars at one position
+	test_cases := []test{
+		{[]*Star3D{{position: Triple{-10, -10, -60}, mass: 1}, {position: Triple{-20, -30, -70}, mass: 1}}, 100, 2},
+		{[]*Star3D{{position: Triple{10, 10, 0}, mass: 1}, {position: Triple{10, 10, 0}, mass: 1}, {position: Triple{50, 50, 0}, mass: 1}}, 100, 3},
+	}
+
+	for _, test_case := range test_cases {
+		ot := ConstructOctTree(test_case.stars, test_case.width)
+		_, mass := UpdateDummyStar3D(ot.root)
+		if mass != test_case.answer {
+			t.Errorf("Error! Output: (%f) but the answer is: (%f)", mass, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestBoundingSector(t *testing.T) {
+	type test struct {
+		u *Universe
+	}
+
+	// two stars far outside the universe beyond the same corner used to split forever
+	u := CreateCustomUniverse()
+	u.AddStar(Star{position: OrderedPair{1e6, 1e6}, mass: 1})
+	u.AddStar(Star{position: OrderedPair{1e6 + 1, 1e6}, mass: 1})
+	var test_case = test{u}
+
+	qt := test_case.u.BuildQuadTree()
+	for _, s := range test_case.u.stars {
+		if !qt.root.InField(s) {
+			t.Errorf("Error! Star at (%f, %f) is outside of the root sector", s.position.x, s.position.y)
+		}
+	}
+	if qt.root.star.mass != 9 {
+		t.Errorf("Error! Output: (%f) but the answer is: (%f)", qt.root.star.mass, 9.0)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestCoincidentQuadTree(t *testing.T) {
+	type test struct {
+		u      *Universe
+		answer float64
+	}
+
+	// two stars at the same position used to split the quadtree forever
+	u := &Universe{width: 100}
+	u.AddStar(Star{position: OrderedPair{10, 10}, mass: 1})
+	u.AddStar(Star{position: OrderedPair{10, 10}, mass: 1})
+	u.AddStar(Star{position: OrderedPair{50, 50}, mass: 1})
+	var test_case = test{u, 3}
+
+	qt := test_case.u.BuildQuadTree()
+	// every star must end up in a leaf of its own
+	leaves := 0
+	stack := []*Node{qt.root}
+	for len(stack) != 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur.star != nil && cur.children == nil {
+			leaves++
+		}
+		stack = append(stack, cur.children...)
+	}
+	if qt.root.star.mass != test_case.answer || leaves != len(test_case.u.stars) {
+		t.Errorf("Error! Output: (%f, %d) but the answer is: (%f, %d)", qt.root.star.mass, leaves, test_case.answer, len(test_case.u.stars))
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestApplyEscapePolicy(t *testing.T) {
+	type test struct {
+		policy    string
+		num_stars int
+		answer    OrderedPair
+		changed   bool
+	}
+
+	test_cases := []test{
+		{"keep", 8, OrderedPair{17, 5}, false},
+		{"remove", 7, OrderedPair{9, 2}, true},
+		{"reflect", 8, OrderedPair{15, 5}, true},
+	}
+
+	for _, test_case := range test_cases {
+		u := CreateCustomUniverse()
+		u.AddStar(Star{position: OrderedPair{17, 5}, velocity: OrderedPair{1, 0}, mass: 1})
+		u.escape_policy = test_case.policy
+		changed := u.ApplyEscapePolicy(1)
+
+		outcome := u.stars[len(u.stars)-1].position
+		if len(u.stars) != test_case.num_stars || outcome != test_case.answer || changed != test_case.changed {
+			t.Errorf("Error! Policy %s left %d stars with the last at (%f, %f)", test_case.policy, len(u.stars), outcome.x, outcome.y)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestApplyCollisionPolicy(t *testing.T) {
+	type test struct {
+		policy      string
+		num_stars   int
+		num_mergers int
+		velocity    OrderedPair
+	}
+
+	test_cases := []test{
+		{"ignore", 3, 0, OrderedPair{1, 0}},
+		{"merge", 2, 1, OrderedPair{-0.5, 0}},
+		{"bounce", 3, 0, OrderedPair{-2, 0}},
+	}
+
+	for _, test_case := range test_cases {
+		var u Universe
+		u.width = 10
+		u.AddStar(Star{position: OrderedPair{1, 1}, velocity: OrderedPair{1, 0}, mass: 1, radius: 0.6})
+		u.AddStar(Star{position: OrderedPair{2, 1}, velocity: OrderedPair{-1, 0}, mass: 3, radius: 0.6})
+		u.AddStar(Star{position: OrderedPair{8, 8}, mass: 1, radius: 0.6})
+		u.collision_policy = test_case.policy
+		u.ApplyCollisionPolicy(1)
+
+		outcome := u.stars[0].velocity
+		if len(u.stars) != test_case.num_stars || len(u.mergers) != test_case.num_mergers || outcome != test_case.velocity {
+			t.Errorf("Error! Policy %s left %d stars and %d mergers with velocity (%f, %f)", test_case.policy, len(u.stars), len(u.mergers), outcome.x, outcome.y)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	type test struct {
+		u          *Universe
+		generation int
+	}
+
+	u := CreateOrbitUniverse()
+	u.stars[1].red, u.stars[1].green, u.stars[1].blue = 1, 2, 3
+	u.stars[1].acceleration = OrderedPair{0.25, -0.5}
+	u.softening = Softening{"spline", 10}
+	u.escape_policy = "reflect"
+	u.collision_policy = "merge"
+	u.integrator = "block"
+	u.time = 4200
+	var test_case = test{u, 42}
+
+	var binary_buf, json_buf bytes.Buffer
+	if err := test_case.u.WriteSnapshotBinary(&binary_buf, test_case.generation); err != nil {
+		t.Fatal(err)
+	}
+	if err := test_case.u.WriteSnapshotJSON(&json_buf, test_case.generation); err != nil {
+		t.Fatal(err)
+	}
+	from_binary, binary_generation, err := ReadSnapshotBinary(&binary_buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from_json, json_generation, err := ReadSnapshotJSON(&json_buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, outcome := range []*Universe{from_binary, from_json} {
+		if !reflect.DeepEqual(outcome, test_case.u) || binary_generation != test_case.generation || json_generation != test_case.generation {
+			t.Errorf("Error! Snapshot did not round trip: %v", outcome)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	type test struct {
+		field  string
+		value  string
+		answer string
+	}
+
+	// the error names the setting of the snapshot that is invalid
+	var test_cases = []test{
+		{"softening", "gaussian", "invalid softening gaussian"},
+		{"escape_policy", "wrap", "unknown escape policy wrap"},
+		{"collision_policy", "smash", "unknown collision policy smash"},
+		{"integrator", "rk4", "unknown integrator rk4"},
+	}
+
+	for _, test_case := range test_cases {
+		filename := filepath.Join(t.TempDir(), "invalid.json")
+		contents := `{"width": 10, "` + test_case.field + `": "` + test_case.value + `", "stars": []}`
+		if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := LoadSnapshot(filename)
+		if err == nil || !strings.HasPrefix(err.Error(), test_case.answer) {
+			t.Errorf("Error! Output: %v but the answer is: %s", err, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	type test struct {
+		filename  string
+		num_stars int
+	}
+
+	test_cases := []test{
+		{"scenarios/jupiter.json", 5},
+		{"scenarios/galaxy.json", 501},
+		{"scenarios/collision.json", 1002},
+		{"scenarios/merger.json", 1002},
+		{"scenarios/disk.json", 1001},
+		{"scenarios/encounter.json", 1002},
+		{"scenarios/periodic.json", 900},
+		{"scenarios/collision3d.json", 1002},
+	}
+
+	for _, test_case := range test_cases {
+		sc, err := LoadScenario(test_case.filename)
+		if err != nil {
+			t.Errorf("Error! %v", err)
+			continue
+		}
+		var outcome int
+		if sc.Dimensions == 3 {
+			outcome = len(sc.BuildUniverse3D().stars)
+		} else {
+			outcome = len(sc.BuildUniverse().stars)
+		}
+		if outcome != test_case.num_stars {
+			t.Errorf("Error! Output: (%d) but the answer is: (%d)", outcome, test_case.num_stars)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestValidateScenario(t *testing.T) {
+	var sc Scenario
+	sc.Name = "broken"
+	sc.Width = -1
+	sc.Integration.Integrator = "rk4"
+
+	err := sc.Validate()
+	if err == nil || !strings.Contains(err.Error(), "width") || !strings.Contains(err.Error(), "rk4") {
+		t.Errorf("Error! Invalid scenario was accepted or not fully reported: %v", err)
+	} else {
+		fmt.Println("Pass!")
+	}
+
+	// settings without a three dimensional counterpart are rejected rather than ignored
+	sc.Dimensions = 3
+	sc.EscapePolicy = "remove"
+	sc.Integration.Integrator = "block"
+	err = sc.Validate()
+	if err == nil || !strings.Contains(err.Error(), "escape_policy \"remove\" is only supported in two dimensions") ||
+		!strings.Contains(err.Error(), "integrator \"block\" is only supported in two dimensions") {
+		t.Errorf("Error! Invalid three dimensional scenario was accepted or not fully reported: %v", err)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestCompareAccuracy(t *testing.T) {
+	type test struct {
+		u      *Universe
+		thetas []float64
+	}
+
+	var test_case = test{CreateCustomUniverse(), []float64{0, 0.5, 1}}
+
+	// with theta = 0 every node is opened, so the tree walk is exact
+	reports := CompareAccuracy(test_case.u, test_case.thetas, 1)
+	if reports[0].max > 1e-12 {
+		t.Errorf("Error! Output: (%e) but the answer is: (0)", reports[0].max)
+	} else {
+		fmt.Println("Pass!")
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].median > reports[i].percentile || reports[i].percentile > reports[i].max {
+			t.Errorf("Error! Percentiles out of order at theta %f", reports[i].theta)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestQuadrupoleAccuracy(t *testing.T) {
+	type test struct {
+		u     *Universe
+		theta float64
+	}
+
+	// a uniform cluster of equal stars, where no single star dominates the force
+	u := CreateClusterUniverse(300)
+	var test_case = test{u, 1.0}
+
+	monopole := CompareAccuracy(test_case.u, []float64{test_case.theta}, 1)[0]
+	test_case.u.quadrupole = true
+	quadrupole := CompareAccuracy(test_case.u, []float64{test_case.theta}, 1)[0]
+
+	if quadrupole.median >= monopole.median {
+		t.Errorf("Error! Quadrupole median error (%e) is not below monopole median error (%e)", quadrupole.median, monopole.median)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+// CreateClusterUniverse places equal-mass stars uniformly at random in a square, with a fixed seed.
+func CreateClusterUniverse(num_of_stars int) *Universe {
+	r := rand.New(rand.NewSource(1))
+	var cluster_universe Universe
+	cluster_universe.width = 1000
+	for i := 0; i < num_of_stars; i++ {
+		cluster_universe.AddStar(Star{position: OrderedPair{r.Float64() * 1000, r.Float64() * 1000}, mass: 1})
+	}
+
+	return &cluster_universe
+}
+
+func TestBlockStep(t *testing.T) {
+	type test struct {
+		u         *Universe
+		num_steps int
+		time      float64
+		tolerance float64
+		answer    float64
+	}
+
+	// a single generation spans a whole orbit, so only the block hierarchy can resolve it
+	u := CreateOrbitUniverse()
+	r := 1.0e7
+	period := 2 * math.Pi * math.Sqrt(r*r*r/(G*u.stars[0].mass))
+	var test_case = test{u, 1, period, 0.01, r}
+
+	forces := TreeForces(0.5, 1)
+	forces(test_case.u, test_case.u.stars)
+	step := BlockStep(0.05)
+	for i := 0; i < test_case.num_steps; i++ {
+		step(test_case.u, test_case.time, forces)
+	}
+	outcome := Distance(test_case.u.stars[0].position, test_case.u.stars[1].position)
+	if math.Abs(outcome-test_case.answer)/test_case.answer > test_case.tolerance {
+		t.Errorf("Error! Output: (%f) but the answer is: (%f)", outcome, test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestAdaptiveStep(t *testing.T) {
+	type test struct {
+		u         *Universe
+		time      float64
+		tolerance float64
+		answer    float64
+	}
+
+	u := CreateOrbitUniverse()
+	r := 1.0e7
+	period := 2 * math.Pi * math.Sqrt(r*r*r/(G*u.stars[0].mass))
+	var test_case = test{u, period, 0.01, r}
+
+	forces := TreeForces(0.5, 1)
+	forces(test_case.u, test_case.u.stars)
+	AdaptiveStep(0.05)(test_case.u, test_case.time, forces)
+	outcome := Distance(test_case.u.stars[0].position, test_case.u.stars[1].position)
+	if math.Abs(outcome-test_case.answer)/test_case.answer > test_case.tolerance {
+		t.Errorf("Error! Output: (%f) but the answer is: (%f)", outcome, test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestKroupaMass(t *testing.T) {
+	type test struct {
+		num_draws int
+		tolerance float64
+		answer    float64
+	}
+
+	// the fraction of stars above half a solar mass, from the integrals of the two segments
+	var test_case = test{100000, 0.01, 0.2388}
+
+	rng := rand.New(rand.NewSource(1))
+	heavy := 0
+	for i := 0; i < test_case.num_draws; i++ {
+		m := KroupaMass(rng) / solar_mass
+		if m < 0.08 || m > 100 {
+			t.Fatalf("Error! Mass %f is outside the mass function", m)
+		}
+		if m > 0.5 {
+			heavy++
+		}
+	}
+
+	outcome := float64(heavy) / float64(test_case.num_draws)
+	if math.Abs(outcome-test_case.answer) > test_case.tolerance {
+		t.Errorf("Error! Output: %f but the answer is: %f", outcome, test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestPlummerSphere(t *testing.T) {
+	type test struct {
+		seed      int64
+		num_stars int
+		tolerance float64
+		answer    float64
+	}
+
+	var test_case = test{3, 300, 1e-9, 1}
+
+	g := PlummerSphere(rand.New(rand.NewSource(test_case.seed)), test_case.num_stars, 1e20, 2e21, 5e22, 5e22, KroupaMass)
+	again := PlummerSphere(rand.New(rand.NewSource(test_case.seed)), test_case.num_stars, 1e20, 2e21, 5e22, 5e22, KroupaMass)
+	if !reflect.DeepEqual(g, again) {
+		t.Errorf("Error! The same seed gave two different clusters")
+	}
+
+	// twice the kinetic energy balances the potential energy
+	u := Universe{stars: g}
+	outcome := 2 * u.KineticEnergy() / -u.PotentialEnergy()
+	if math.Abs(outcome-test_case.answer) > test_case.tolerance {
+		t.Errorf("Error! Output: %f but the answer is: %f", outcome, test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestSetupEncounter(t *testing.T) {
+	type test struct {
+		pericenter   float64
+		eccentricity float64
+		separation   float64
+	}
+
+	test_cases := []test{
+		{1e9, 1, 5e9},
+		{1e9, 1.5, 3e9},
+	}
+
+	for _, test_case := range test_cases {
+		g0 := Galaxy{&Star{mass: 2e30}}
+		g1 := Galaxy{&Star{mass: 1e30}}
+		SetupEncounter(g0, g1, test_case.pericenter, test_case.eccentricity, test_case.separation, [2]float64{0, 0}, OrderedPair{5e9, 5e9})
+
+		// recover the orbit from the relative position and velocity of the two stars
+		total_mass := 3e30
+		r := OrderedPair{g1[0].position.x - g0[0].position.x, g1[0].position.y - g0[0].position.y}
+		v := OrderedPair{g1[0].velocity.x - g0[0].velocity.x, g1[0].velocity.y - g0[0].velocity.y}
+		h := r.x*v.y - r.y*v.x
+		energy := 0.5*(v.x*v.x+v.y*v.y) - G*total_mass/math.Hypot(r.x, r.y)
+		eccentricity := math.Sqrt(1 + 2*energy*h*h/(G*G*total_mass*total_mass))
+		pericenter := h * h / (G * total_mass * (1 + eccentricity))
+
+		com, momentum, _ := GalaxyCenterOfMass(append(g0, g1...))
+		if math.Abs(eccentricity-test_case.eccentricity) > 1e-6 || math.Abs(pericenter/test_case.pericenter-1) > 1e-6 ||
+			math.Abs(math.Hypot(r.x, r.y)/test_case.separation-1) > 1e-9 || math.Abs(com.x-5e9) > 1 || math.Abs(momentum.y) > 1e-9 {
+			t.Errorf("Error! Output: e = %f and q = %e but the answer is: e = %f and q = %e", eccentricity, pericenter, test_case.eccentricity, test_case.pericenter)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestPeriodicForce(t *testing.T) {
+	type test struct {
+		position OrderedPair
+		answer   OrderedPair
+	}
+
+	// the force of an infinite tiling of unit boxes on a unit mass, from many more images than the table uses
+	reference := func(d OrderedPair) OrderedPair {
+		near, far := ImageSum(d, 64), ImageSum(d, 128)
+		r := math.Hypot(d.x, d.y)
+		return OrderedPair{G * (d.x/(r*r*r) + 2*far.x - near.x), G * (d.y/(r*r*r) + 2*far.y - near.y)}
+	}
+
+	test_cases := []test{
+		{OrderedPair{0.75, 0.25}, OrderedPair{0, 0}}, // half a box away, pulled equally both ways
+		{OrderedPair{0.55, 0.35}, reference(OrderedPair{0.3, 0.1})},
+		{OrderedPair{0.05, 0.95}, reference(OrderedPair{-0.2, -0.3})}, // the nearest image is across both edges
+	}
+
+	for _, test_case := range test_cases {
+		var u Universe
+		u.width = 1
+		u.escape_policy = "periodic"
+		u.AddStar(Star{position: OrderedPair{0.25, 0.25}, mass: 1})
+		u.AddStar(Star{position: test_case.position, mass: 1})
+
+		// within a thousandth of the pull of a star half a box away
+		outcome := u.stars[0].ComputeNetForce(u.BuildQuadTree(), 0.5)
+		if math.Hypot(outcome.x-test_case.answer.x, outcome.y-test_case.answer.y) > 4e-3*G {
+			t.Errorf("Error! Output: (%e, %e) but the answer is: (%e, %e)", outcome.x, outcome.y, test_case.answer.x, test_case.answer.y)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestPeriodicPotential(t *testing.T) {
+	type test struct {
+		d OrderedPair
+	}
+
+	// the correction to the potential must be the potential of the correction to the force, within the accuracy of the tables
+	h := 1.0 / (2 * ewald_table_size)
+	test_cases := []test{
+		{OrderedPair{0.3, 0.1}},
+		{OrderedPair{-0.2, -0.3}},
+		{OrderedPair{0.45, 0.05}},
+	}
+
+	for _, test_case := range test_cases {
+		d := test_case.d
+		answer := EwaldCorrection(d, 1)
+		outcome := OrderedPair{
+			-(EwaldPotentialCorrection(OrderedPair{d.x + h, d.y}, 1) - EwaldPotentialCorrection(OrderedPair{d.x - h, d.y}, 1)) / (2 * h),
+			-(EwaldPotentialCorrection(OrderedPair{d.x, d.y + h}, 1) - EwaldPotentialCorrection(OrderedPair{d.x, d.y - h}, 1)) / (2 * h),
+		}
+		if math.Hypot(outcome.x-answer.x, outcome.y-answer.y) > 4e-3 {
+			t.Errorf("Error! Output: (%f, %f) but the answer is: (%f, %f)", outcome.x, outcome.y, answer.x, answer.y)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+
+	// with theta = 0 the tree walk sees every star at the same image as the exact sum
+	var u Universe
+	u.width = 1
+	u.escape_policy = "periodic"
+	u.AddStar(Star{position: OrderedPair{0.25, 0.25}, mass: 1})
+	u.AddStar(Star{position: OrderedPair{0.55, 0.35}, mass: 1})
+	u.AddStar(Star{position: OrderedPair{0.9, 0.8}, mass: 2})
+	exact, tree := u.PotentialEnergy(), u.TreePotentialEnergy(0)
+	if math.Abs(tree-exact) > 1e-12*math.Abs(exact) {
+		t.Errorf("Error! Output: (%e) but the answer is: (%e)", tree, exact)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestFlatTree(t *testing.T) {
+	type test struct {
+		escape_policy string
+		quadrupole    bool
+	}
+
+	test_cases := []test{
+		{"keep", false},
+		{"keep", true},
+		{"periodic", false},
+	}
+
+	for _, test_case := range test_cases {
+		u := CreateClusterUniverse(500)
+		u.escape_policy = test_case.escape_policy
+		u.quadrupole = test_case.quadrupole
+
+		// both trees split the same bounding sector, so they open the same nodes
+		qt := u.BuildQuadTree()
+		ft := u.BuildFlatTree()
+		worst := 0.0
+		for _, s := range u.stars {
+			answer := s.ComputeNetForce(qt, 0.5)
+			outcome := ft.NetForce(s, 0.5)
+			worst = math.Max(worst, math.Hypot(outcome.x-answer.x, outcome.y-answer.y)/math.Hypot(answer.x, answer.y))
+		}
+
+		if worst > 1e-9 {
+			t.Errorf("Error! Output: a relative difference of %e from the quadtree with policy %s and quadrupole %t", worst, test_case.escape_policy, test_case.quadrupole)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestIsSelf(t *testing.T) {
+	type test struct {
+		s1, s2 *Star
+		answer bool
+	}
+
+	a := &Star{position: OrderedPair{1, 2}, velocity: OrderedPair{3, 4}, mass: 1, id: 1}
+	twin := &Star{position: OrderedPair{1, 2}, velocity: OrderedPair{3, 4}, mass: 1, id: 2}
+	moved := a.CopyStar()
+	moved.position = OrderedPair{5, 6}
+
+	test_cases := []test{
+		{a, a.CopyStar(), true},
+		{a, twin, false}, // the same state, but a different star
+		{a, moved, true}, // a copy that has moved since
+	}
+
+	for _, test_case := range test_cases {
+		outcome := test_case.s1.IsSelf(test_case.s2)
+		if outcome != test_case.answer {
+			t.Errorf("Error! Output: %t but the answer is: %t", outcome, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestTrajectoryObserver(t *testing.T) {
+	type test struct {
+		num_gens  int
+		frequency int
+		answer    int
+	}
+
+	var test_case = test{10, 5, 3}
+
+	// only the orbiting star is tracked, and it keeps its id through the copies made every generation
+	trajectories := NewTrajectories([]int{2})
+	u := CreateOrbitUniverse()
+	period := 2 * math.Pi * math.Sqrt(1e21/(G*u.stars[0].mass))
+	final_universe := StreamBarnesHut(u, test_case.num_gens, period/1000, 0.5, "leapfrog", 1, TrajectoryObserver(trajectories, test_case.frequency))
+
+	path := trajectories[2]
+	if len(trajectories) != 1 || len(path) != test_case.answer || path[len(path)-1].position != final_universe.stars[1].position {
+		t.Errorf("Error! Output: %d points but the answer is: %d", len(path), test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestOrbitalElements(t *testing.T) {
+	type test struct {
+		eccentricity float64
+		answer       OrbitalElements
+	}
+
+	// a star at periapsis, 1e7 m from the primary along the x axis
+	var test_cases = []test{
+		{0, OrbitalElements{1e7, 0, 0, 0}},
+		{0.5, OrbitalElements{2e7, 0.5, 0, 0}},
+	}
+
+	for _, test_case := range test_cases {
+		u := CreateOrbitUniverse()
+		primary, s := u.stars[0], u.stars[1]
+		mu := G * (primary.mass + s.mass)
+		s.velocity.y = math.Sqrt(mu * (1 + test_case.eccentricity) / 1e7)
+		test_case.answer.period = 2 * math.Pi * math.Sqrt(math.Pow(test_case.answer.semi_major_axis, 3)/mu)
+
+		outcome := s.ComputeOrbitalElements(primary)
+		if math.Abs(outcome.semi_major_axis-test_case.answer.semi_major_axis) > 1e-6*test_case.answer.semi_major_axis ||
+			math.Abs(outcome.eccentricity-test_case.answer.eccentricity) > 1e-9 ||
+			math.Abs(outcome.period-test_case.answer.period) > 1e-6*test_case.answer.period ||
+			(test_case.eccentricity > 0 && math.Abs(outcome.periapsis_argument) > 1e-9) {
+			t.Errorf("Error! Output: %v but the answer is: %v", outcome, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestMeasuredPeriod(t *testing.T) {
+	type test struct {
+		num_gens  int
+		frequency int
+		tolerance float64
+	}
+
+	var test_case = test{1500, 10, 1e-3}
+
+	u := CreateOrbitUniverse()
+	period := 2 * math.Pi * math.Sqrt(1e21/(G*u.stars[0].mass))
+	analysis := NewOrbitAnalysis(1, []int{2})
+	StreamBarnesHut(u, test_case.num_gens, period/1000, 0.5, "leapfrog", 1, OrbitObserver(analysis, test_case.frequency))
+
+	report := PeriodReport{"orbiter", 2, analysis.MeasuredPeriod(2), period}
+	if report.RelativeError() > test_case.tolerance {
+		t.Errorf("Error! Output: %g but the answer is: %g", report.measured, period)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestCamera2D(t *testing.T) {
+	type test struct {
+		camera *Camera2D
+		answer Viewport
+	}
+
+	// the orbit universe has its stars at (2e7, 2e7) and (3e7, 2e7) in a universe 4e7 wide
+	var test_cases = []test{
+		{nil, Viewport{OrderedPair{2e7, 2e7}, 4e7}},
+		{NewCamera2D("fixed", 0, Viewport{OrderedPair{1e7, 1e7}, 1e7}, 0, 0), Viewport{OrderedPair{1e7, 1e7}, 1e7}},
+		{NewCamera2D("fit", 0, Viewport{}, 0.5, 0), Viewport{OrderedPair{2.5e7, 2e7}, 1.5e7}},
+		{NewCamera2D("star", 2, Viewport{OrderedPair{}, 1e6}, 0, 0), Viewport{OrderedPair{3e7, 2e7}, 1e6}},
+		// halfway from the whole universe to a universe 1e7 wide centered on the star
+		{NewCamera2D("star", 2, Viewport{OrderedPair{}, 1e7}, 0, 0.5), Viewport{OrderedPair{2.5e7, 2e7}, 2e7}},
+	}
+
+	for _, test_case := range test_cases {
+		outcome := test_case.camera.Update(CreateOrbitUniverse())
+		if math.Abs(outcome.center.x-test_case.answer.center.x) > 1 || math.Abs(outcome.center.y-test_case.answer.center.y) > 1 ||
+			math.Abs(outcome.width-test_case.answer.width) > 1 {
+			t.Errorf("Error! Output: %v but the answer is: %v", outcome, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestMapColor(t *testing.T) {
+	type test struct {
+		colormap string
+		value    float64
+		answer   [3]uint8
+	}
+
+	var test_cases = []test{
+		{"gray", 0, [3]uint8{0, 0, 0}},
+		{"gray", 0.5, [3]uint8{128, 128, 128}},
+		{"gray", 2, [3]uint8{255, 255, 255}},
+		{"", 1, [3]uint8{255, 255, 255}},
+		{"viridis", 0, [3]uint8{68, 1, 84}},
+	}
+
+	for _, test_case := range test_cases {
+		r, g, b := MapColor(test_case.colormap, test_case.value)
+		if [3]uint8{r, g, b} != test_case.answer {
+			t.Errorf("Error! Output: %v but the answer is: %v", [3]uint8{r, g, b}, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestRenderModes(t *testing.T) {
+	type test struct {
+		velocity OrderedPair
+		answer   [3]uint8
+	}
+
+	// a star 1e7 m to the right of the primary, which is the center of mass, at the fastest speed
+	var test_cases = []test{
+		{OrderedPair{1, 0}, [3]uint8{255, 0, 0}},  // moving outwards
+		{OrderedPair{-1, 0}, [3]uint8{0, 255, 0}}, // moving inwards
+		{OrderedPair{0, 1}, [3]uint8{0, 0, 255}},  // moving around
+	}
+
+	for _, test_case := range test_cases {
+		s := Star{position: OrderedPair{1e7, 0}, velocity: test_case.velocity}
+		r, g, b := s.VelocityColor(OrderedPair{}, OrderedPair{}, 1)
+		if [3]uint8{r, g, b} != test_case.answer {
+			t.Errorf("Error! Output: %v but the answer is: %v", [3]uint8{r, g, b}, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+
+	// the surface density of the whole universe holds all of its mass
+	u := CreateOrbitUniverse()
+	view := u.FullView()
+	density := u.SurfaceDensity(view, 100, 10)
+	total := 0.0
+	for i := range density {
+		for j := range density[i] {
+			total += density[i][j] * view.width * view.width / 100
+		}
+	}
+	if math.Abs(total-(u.stars[0].mass+u.stars[1].mass)) > 1e-9*total {
+		t.Errorf("Error! Output: %g but the answer is: %g", total, u.stars[0].mass+u.stars[1].mass)
+	} else {
+		fmt.Println("Pass!")
+	}
+
+	// every mode draws a frame of the requested size
+	for _, mode := range []string{"stars", "density", "velocity"} {
+		img := u.DrawViewToCanvas(100, 1, view, RenderStyle{mode: mode, tree_overlay: true}, nil, 0)
+		if img.Bounds().Dx() != 100 {
+			t.Errorf("Error! Output: %d but the answer is: %d", img.Bounds().Dx(), 100)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestFrameSinks(t *testing.T) {
+	type test struct {
+		format string
+		answer int
+	}
+
+	directory := t.TempDir()
+	name := filepath.Join(directory, "sinks")
+	u := CreateOrbitUniverse()
+	frames := []image.Image{
+		u.DrawViewToCanvas(40, 1e5, u.FullView(), RenderStyle{}, nil, 0),
+		u.DrawViewToCanvas(40, 1e5, u.FullView(), RenderStyle{mode: "density"}, nil, 0),
+		u.DrawViewToCanvas(40, 1e5, u.FullView(), RenderStyle{mode: "velocity"}, nil, 0),
+	}
+
+	// the number of frames found in the output
+	var test_cases = []test{
+		{"png", 3},
+		{"apng", 3},
+		{"pipe", 3},
+	}
+
+	for _, test_case := range test_cases {
+		sink, err := NewFrameSink(test_case.format, name, 10, []string{"sh", "-c", "cat > {name}.raw"})
+		if err != nil {
+			t.Errorf("Error! %v", err)
+			continue
+		}
+		for _, frame := range frames {
+			sink.WriteFrame(frame)
+		}
+		if err := sink.Close(); err != nil {
+			t.Errorf("Error! %v", err)
+			continue
+		}
+
+		outcome := 0
+		switch test_case.format {
+		case "png":
+			paths, _ := filepath.Glob(name + ".frames/frame_*.png")
+			outcome = len(paths)
+		case "apng":
+			// the default image is the first frame, and the animation control chunk holds the number of frames
+			data, _ := os.ReadFile(name + ".out.png")
+			img, err := png.Decode(bytes.NewReader(data))
+			if err == nil && img.At(20, 20) == NRGBAFrame(frames[0]).At(20, 20) {
+				outcome = int(binary.BigEndian.Uint32(data[apng_actl_offset+8:]))
+			}
+		case "pipe":
+			info, err := os.Stat(name + ".raw")
+			if err == nil {
+				outcome = int(info.Size()) / (40 * 40 * 4)
+			}
+		}
+		if outcome != test_case.answer {
+			t.Errorf("Error! Output: %d frames from %s but the answer is: %d", outcome, test_case.format, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	type test struct {
+		args   []string
+		usage  bool // whether the arguments are a usage error
+		answer IntegrationOptions
+	}
+
+	// jupiter.json integrates 1000000 generations of 1 second with theta 0.5
+	var test_cases = []test{
+		{[]string{"run"}, true, IntegrationOptions{}},
+		{[]string{"run", "a.json", "b.json"}, true, IntegrationOptions{}},
+		{[]string{"run", "-theta", "x", "jupiter"}, true, IntegrationOptions{}},
+		{[]string{"run", "-format", "mp4", "jupiter"}, true, IntegrationOptions{}},
+		{[]string{"launch", "jupiter"}, true, IntegrationOptions{}},
+		{[]string{"run", "jupiter"}, false, IntegrationOptions{1000000, 1, 0.5, "euler", 0}},
+		{[]string{"run", "-theta", "0.7", "-dt", "10", "-generations", "50", "jupiter"}, false, IntegrationOptions{50, 10, 0.7, "euler", 0}},
+	}
+
+	for _, test_case := range test_cases {
+		opts, filename, err := ParseCommand(test_case.args[0], test_case.args[1:])
+		var usage_error UsageError
+		if errors.As(err, &usage_error) != test_case.usage {
+			t.Errorf("Error! Output: %v for %v but the answer is: usage error %v", err, test_case.args, test_case.usage)
+			continue
+		}
+		if test_case.usage {
+			fmt.Println("Pass!")
+			continue
+		}
+
+		sc, err := LoadScenario("scenarios/" + filename + ".json")
+		if err != nil {
+			t.Errorf("Error! %v", err)
+			continue
+		}
+		opts.Apply(sc)
+		if sc.Integration != test_case.answer || sc.Validate() != nil {
+			t.Errorf("Error! Output: %v but the answer is: %v", sc.Integration, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+
+	// no arguments at all are a usage error rather than a panic
+	var usage_error UsageError
+	if err := RunCommand(context.Background(), nil); !errors.As(err, &usage_error) {
+		t.Errorf("Error! Output: %v but the answer is: a usage error", err)
+	} else {
+		fmt.Println("Pass!")
+	}
+
+	// the view of a scenario with three dimensions comes from the command line
+	opts, filename, err := ParseCommand("run", []string{"-azimuth", "30", "-elevation", "45", "collision3d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := LoadScenario(ScenarioFile(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Apply(sc)
+	if sc.Rendering.Azimuth != 30 || sc.Rendering.Elevation != 45 || sc.Validate() != nil {
+		t.Errorf("Error! Output: (%f, %f, %v) but the answer is: (30, 45, <nil>)", sc.Rendering.Azimuth, sc.Rendering.Elevation, sc.Validate())
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestStreamBarnesHutContext(t *testing.T) {
+	type test struct {
+		cancel_at int
+		answer    int
+	}
+
+	// the observer cancels the run once it has seen cancel_at generations
+	var test_cases = []test{
+		{0, 0},
+		{7, 7},
+		{100, 20},
+	}
+
+	for _, test_case := range test_cases {
+		ctx, cancel := context.WithCancel(context.Background())
+		u := CreateOrbitUniverse()
+		observed := -1
+		final_universe, completed, err := StreamBarnesHutContext(ctx, u, 20, 1, 0.5, "leapfrog", 1, func(generation int, u *Universe) {
+			observed = generation
+			if generation == test_case.cancel_at {
+				cancel()
+			}
+		})
+		cancel()
+
+		stopped := test_case.answer < 20
+		if completed != test_case.answer || observed != test_case.answer || (err != nil) != stopped || final_universe == nil {
+			t.Errorf("Error! Output: %d generations (%v) but the answer is: %d", completed, err, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestElapsedTime(t *testing.T) {
+	type test struct {
+		num_gens int
+		time     float64
+	}
+
+	// a run resumed with another time step keeps the times of the original run and counts on from them
+	var test_cases = []test{{10, 100}, {5, 50}}
+	var answer = []float64{0, 500, 1000, 1250}
+
+	diagnostics := make([]Diagnostics, 0)
+	u := CreateOrbitUniverse()
+	generation := 0
+	for _, test_case := range test_cases {
+		observer := OffsetObserver(DiagnosticsObserver(&diagnostics, 0.5, 5), generation)
+		u = StreamBarnesHut(u, test_case.num_gens, test_case.time, 0.5, "leapfrog", 1, observer)
+		generation += test_case.num_gens
+	}
+
+	outcome := make([]float64, len(diagnostics))
+	for i, d := range diagnostics {
+		outcome[i] = d.time
+	}
+	if !reflect.DeepEqual(outcome, answer) || u.time != answer[len(answer)-1] {
+		t.Errorf("Error! Output: %v but the answer is: %v", outcome, answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+// ReadCSV reads back every record of a CSV file written by one of the writers, header first.
+func ReadCSV(t *testing.T, filename string) [][]string {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	type test struct {
+		diagnostics []Diagnostics
+		answer      [][]string
+	}
+
+	// the energy error is relative to the total energy of the first row
+	var test_case = test{
+		[]Diagnostics{
+			{0, 0, 1, -4, -4.5, OrderedPair{1, 0}, 2},
+			{5, 250.5, 2, -6, -6.5, OrderedPair{1, 0.25}, 2},
+		},
+		[][]string{
+			{"generation", "time", "kinetic", "potential_exact", "potential_tree", "total_energy", "energy_error", "momentum_x", "momentum_y", "angular_momentum"},
+			{"0", "0", "1", "-4", "-4.5", "-3", "0", "1", "0", "2"},
+			{"5", "250.5", "2", "-6", "-6.5", "-4", "0.3333333333333333", "1", "0.25", "2"},
+		},
+	}
+
+	filename := filepath.Join(t.TempDir(), "diagnostics.csv")
+	if err := WriteDiagnostics(test_case.diagnostics, filename); err != nil {
+		t.Fatal(err)
+	}
+	outcome := ReadCSV(t, filename)
+	if !reflect.DeepEqual(outcome, test_case.answer) {
+		t.Errorf("Error! Output: %v but the answer is: %v", outcome, test_case.answer)
+	} else {
+		fmt.Println("Pass!")
+	}
+}
+
+func TestCSVWriters(t *testing.T) {
+	type test struct {
+		name   string
+		write  func(filename string) error
+		answer [][]string
+	}
+
+	star := &Star{position: OrderedPair{1, 2}, velocity: OrderedPair{3, 4}, mass: 5, id: 7}
+	other := Star{position: OrderedPair{3, 2}, velocity: OrderedPair{0, 0}, mass: 15, id: 8}
+	analysis := NewOrbitAnalysis(1, []int{2})
+	analysis.records = append(analysis.records, OrbitRecord{10, 25, 2, OrbitalElements{1e21, 0.5, 100, 0.25}})
+
+	var test_cases = []test{
+		{"escapes", func(filename string) error {
+			return WriteEscapes([]EscapeEvent{{3, star}}, filename)
+		}, [][]string{
+			{"generation", "id", "x", "y", "vx", "vy", "mass"},
+			{"3", "7", "1", "2", "3", "4", "5"},
+		}},
+		{"mergers", func(filename string) error {
+			return WriteMergers([]MergerEvent{{4, other, *star}}, filename)
+		}, [][]string{
+			{"generation", "primary_id", "secondary_id", "x", "y", "primary_mass", "secondary_mass", "merged_mass"},
+			{"4", "8", "7", "2.5", "2", "15", "5", "20"},
+		}},
+		{"trajectories", func(filename string) error {
+			return WriteTrajectories(Trajectories{7: {{2, 12.5, star.position, star.velocity}}, 8: {}}, filename)
+		}, [][]string{
+			{"id", "generation", "time", "x", "y", "vx", "vy"},
+			{"7", "2", "12.5", "1", "2", "3", "4"},
+		}},
+		{"orbits", func(filename string) error {
+			return WriteOrbits(analysis, filename)
+		}, [][]string{
+			{"id", "generation", "time", "semi_major_axis", "eccentricity", "period", "periapsis_argument"},
+			{"2", "10", "25", "1e+21", "0.5", "100", "0.25"},
+		}},
+		{"periods", func(filename string) error {
+			return WritePeriodReport([]PeriodReport{{"orbiter", 2, 110, 100}}, filename)
+		}, [][]string{
+			{"name", "id", "measured_period", "known_period", "relative_error"},
+			{"orbiter", "2", "110", "100", "0.1"},
+		}},
+		{"accuracy", func(filename string) error {
+			return WriteAccuracyReport([]AccuracyReport{{0.5, 0.001, 0.01, 0.1, time.Second, 4 * time.Second}}, filename)
+		}, [][]string{
+			{"theta", "median_error", "p99_error", "max_error", "tree_seconds", "direct_seconds", "speedup"},
+			{"0.5", "0.001", "0.01", "0.1", "1", "4", "4"},
+		}},
+	}
+
+	for _, test_case := range test_cases {
+		filename := filepath.Join(t.TempDir(), test_case.name+".csv")
+		if err := test_case.write(filename); err != nil {
+			t.Fatal(err)
+		}
+		outcome := ReadCSV(t, filename)
+		if !reflect.DeepEqual(outcome, test_case.answer) {
+			t.Errorf("Error! Output: %v but the answer is: %v", outcome, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestOffsetObserver(t *testing.T) {
+	type test struct {
+		offset int
+		answer []int
+	}
+
+	// a resumed run does not see its first generation again, and counts on from the snapshot
+	var test_cases = []test{
+		{0, []int{0, 1, 2, 3}},
+		{10, []int{11, 12, 13}},
+	}
+
+	for _, test_case := range test_cases {
+		outcome := make([]int, 0)
+		StreamBarnesHut(CreateOrbitUniverse(), 3, 1, 0.5, "leapfrog", 1, OffsetObserver(func(generation int, u *Universe) {
+			outcome = append(outcome, generation)
+		}, test_case.offset))
+		if !reflect.DeepEqual(outcome, test_case.answer) {
+			t.Errorf("Error! Output: %v but the answer is: %v", outcome, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	type test struct {
+		first, generation, num_gens int
+		elapsed                     time.Duration
+		answer                      string
+	}
+
+	// a resumed run counts its speed from the generation it started at
+	var test_cases = []test{
+		{0, 100, 1000, 10 * time.Second, "generation 100/1000 (10.0%), elapsed 10s, ETA 1m30s, 10.0 steps/s"},
+		{0, 1000, 1000, 50 * time.Second, "generation 1000/1000 (100.0%), elapsed 50s, ETA 0s, 20.0 steps/s"},
+		{500, 600, 1000, 10 * time.Second, "generation 600/1000 (60.0%), elapsed 10s, ETA 40s, 10.0 steps/s"},
+	}
+
+	for _, test_case := range test_cases {
+		outcome := FormatProgress(test_case.first, test_case.generation, test_case.num_gens, test_case.elapsed)
+		if outcome != test_case.answer {
+			t.Errorf("Error! Output: %s but the answer is: %s", outcome, test_case.answer)
+		} else {
+			fmt.Println("Pass!")
+		}
+	}
+
+	// the last generation is always reported
+	var buffer bytes.Buffer
+	report := ProgressReporter(&buffer, 0, 5, time.Hour)
+	StreamBarnesHut(CreateOrbitUniverse(), 5, 1, 0.5, "leapfrog", 1, func(generation int, u *Universe) {
+		report(generation)
+	})
+	if !strings.HasPrefix(buffer.String(), "generation 5/5 (100.0%)") {
+		t.Errorf("Error! Output: %q but the answer is: %q", buffer.String(), "generation 5/5 (100.0%) ...")
+	} else {
+		fmt.Println("Pass!")
+	}
+}
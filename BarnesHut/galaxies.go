@@ -0,0 +1,313 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const solar_radius = 696340000 // radius of sun in m
+
+// MassFunction draws the mass (in kg) of a single star from the given source of randomness.
+type MassFunction func(rng *rand.Rand) float64
+
+// kroupa_segments are the pieces of the Kroupa (2001) initial mass function, dN/dm proportional to m^-alpha,
+// between the given masses in solar masses.
+var kroupa_segments = []struct {
+	low, high, alpha float64
+}{
+	{0.08, 0.5, 1.3},
+	{0.5, 100, 2.3},
+}
+
+// ValidMassFunction checks whether a mass function is known: "" or "solar" gives every star the mass of the sun,
+// and "kroupa" draws masses from the Kroupa initial mass function.
+func ValidMassFunction(name string) bool {
+	return name == "" || name == "solar" || name == "kroupa"
+}
+
+// GetMassFunction looks up a MassFunction by name.
+// Input: the name of a mass function accepted by ValidMassFunction.
+// Output: the corresponding MassFunction.
+func GetMassFunction(name string) MassFunction {
+	switch name {
+	case "", "solar":
+		return SolarMass
+	case "kroupa":
+		return KroupaMass
+	}
+
+	panic("Error: unknown mass function " + name + ".")
+}
+
+// SolarMass is the MassFunction of the original simulations, in which every star weighs one solar mass.
+func SolarMass(rng *rand.Rand) float64 {
+	return solar_mass
+}
+
+// KroupaMass draws a mass from the Kroupa initial mass function by choosing a segment with probability
+// proportional to the number of stars in it, and then inverting the power law within that segment.
+func KroupaMass(rng *rand.Rand) float64 {
+	// the segments join continuously, so each one is scaled by the previous one at their common mass
+	weights := make([]float64, len(kroupa_segments))
+	var scale, total float64 = 1, 0
+	for i, seg := range kroupa_segments {
+		if i > 0 {
+			scale *= math.Pow(seg.low, seg.alpha-kroupa_segments[i-1].alpha)
+		}
+		weights[i] = scale * (math.Pow(seg.high, 1-seg.alpha) - math.Pow(seg.low, 1-seg.alpha)) / (1 - seg.alpha)
+		total += weights[i]
+	}
+
+	choice := rng.Float64() * total
+	i := 0
+	for i < len(weights)-1 && choice > weights[i] {
+		choice -= weights[i]
+		i++
+	}
+
+	seg := kroupa_segments[i]
+	low, high := math.Pow(seg.low, 1-seg.alpha), math.Pow(seg.high, 1-seg.alpha)
+
+	return math.Pow(low+rng.Float64()*(high-low), 1/(1-seg.alpha)) * solar_mass
+}
+
+// MainSequenceRadius approximates the radius of a main sequence star from its mass, R proportional to M^0.8.
+func MainSequenceRadius(mass float64) float64 {
+	return solar_radius * math.Pow(mass/solar_mass, 0.8)
+}
+
+// NewStar creates a white star of the given mass, with a main sequence radius.
+func NewStar(mass float64) *Star {
+	var s Star
+	s.mass = mass
+	s.radius = MainSequenceRadius(mass)
+	s.red, s.green, s.blue = 255, 255, 255
+
+	return &s
+}
+
+// ExponentialDisk creates a disk galaxy whose surface density falls off as exp(-R / scale_length) out to max_radius,
+// around a central black hole of the given mass (none if zero). Every star is put on a circular orbit
+// whose speed accounts for the black hole and for all the disk mass closer to the center than the star.
+// Input: a source of randomness, the number of stars, the scale length and the outer radius of the disk,
+// the mass of the black hole, the center of the disk, and a MassFunction for the stars.
+// Output: the Galaxy, with the black hole (if any) as its last star.
+func ExponentialDisk(rng *rand.Rand, num_of_stars int, scale_length, max_radius, central_mass, x, y float64, masses MassFunction) Galaxy {
+	g := make(Galaxy, num_of_stars)
+	radii := make([]float64, num_of_stars)
+
+	for i := range g {
+		// the number of stars at radius R grows as R * exp(-R / scale_length), a gamma distribution of shape 2
+		dist := math.Inf(1)
+		for dist > max_radius {
+			dist = -scale_length * math.Log((1-rng.Float64())*(1-rng.Float64()))
+		}
+		angle := rng.Float64() * 2 * math.Pi
+
+		g[i] = NewStar(masses(rng))
+		g[i].position.x = x + dist*math.Cos(angle)
+		g[i].position.y = y + dist*math.Sin(angle)
+		radii[i] = dist
+	}
+
+	// go outwards from the center, accumulating the mass enclosed by each star
+	order := make([]int, num_of_stars)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return radii[order[a]] < radii[order[b]] })
+
+	enclosed := central_mass
+	for _, i := range order {
+		s := g[i]
+		if radii[i] > 0 {
+			speed := math.Sqrt(G * enclosed / radii[i])
+			s.velocity.x = -speed * (s.position.y - y) / radii[i]
+			s.velocity.y = speed * (s.position.x - x) / radii[i]
+		}
+		enclosed += s.mass
+	}
+
+	if central_mass > 0 {
+		var blackhole Star
+		blackhole.mass = central_mass
+		blackhole.position = OrderedPair{x, y}
+		blackhole.blue = 255
+		blackhole.radius = 10 * solar_radius // ten times that of a normal star (to make it visible as large)
+		g = append(g, &blackhole)
+	}
+
+	return g
+}
+
+// PlummerSphere3D creates a spherical cluster following the Plummer profile with scale radius a,
+// truncated at max_radius. Positions and speeds are drawn from the distribution function of the profile
+// (Aarseth, Henon and Wielen 1974), and the velocities are then scaled so that the cluster is in virial equilibrium.
+// Input: a source of randomness, the number of stars, the scale radius, the outer radius, the center
+// and a MassFunction for the stars.
+// Output: the Galaxy3D, at rest at the given center.
+func PlummerSphere3D(rng *rand.Rand, num_of_stars int, a, max_radius, x, y, z float64, masses MassFunction) Galaxy3D {
+	g, total_mass := NewStars3D(rng, num_of_stars, masses)
+
+	for _, s := range g {
+		// invert the enclosed mass M(r) = M r^3 / (r^2 + a^2)^(3/2)
+		r := math.Inf(1)
+		for r > max_radius {
+			r = a / math.Sqrt(math.Pow(1-rng.Float64(), -2.0/3.0)-1)
+		}
+
+		// draw q = v / v_escape by rejection from g(q) = q^2 (1 - q^2)^(7/2), whose maximum is below 0.1
+		q := 0.0
+		for {
+			q = rng.Float64()
+			if 0.1*rng.Float64() < q*q*math.Pow(1-q*q, 3.5) {
+				break
+			}
+		}
+		v_escape := math.Sqrt(2*G*total_mass) * math.Pow(r*r+a*a, -0.25)
+
+		s.position = RandomDirection(rng, r)
+		s.velocity = RandomDirection(rng, q*v_escape)
+	}
+
+	Virialize3D(g, Triple{x, y, z})
+
+	return g
+}
+
+// HernquistSphere3D creates a spherical galaxy following the Hernquist (1990) profile with scale radius a,
+// truncated at max_radius. Every velocity component is drawn from a Gaussian with the isotropic dispersion
+// given by the Jeans equation, below the local escape speed, and the velocities are then scaled
+// so that the galaxy is in virial equilibrium.
+// Input: a source of randomness, the number of stars, the scale radius, the outer radius, the center
+// and a MassFunction for the stars.
+// Output: the Galaxy3D, at rest at the given center.
+func HernquistSphere3D(rng *rand.Rand, num_of_stars int, a, max_radius, x, y, z float64, masses MassFunction) Galaxy3D {
+	g, total_mass := NewStars3D(rng, num_of_stars, masses)
+
+	for _, s := range g {
+		// invert the enclosed mass M(r) = M r^2 / (r + a)^2
+		r := math.Inf(1)
+		for r > max_radius {
+			root := math.Sqrt(rng.Float64())
+			r = a * root / (1 - root)
+		}
+
+		sigma := math.Sqrt(HernquistDispersion(r, a, total_mass))
+		v_escape := math.Sqrt(2 * G * total_mass / (r + a))
+		velocity := Triple{math.Inf(1), 0, 0}
+		for Distance3D(velocity, Triple{}) >= v_escape {
+			velocity = Triple{rng.NormFloat64() * sigma, rng.NormFloat64() * sigma, rng.NormFloat64() * sigma}
+		}
+
+		s.position = RandomDirection(rng, r)
+		s.velocity = velocity
+	}
+
+	Virialize3D(g, Triple{x, y, z})
+
+	return g
+}
+
+// HernquistDispersion is the squared one dimensional velocity dispersion of an isotropic Hernquist sphere
+// of total mass m and scale radius a at radius r (Hernquist 1990, equation 10).
+func HernquistDispersion(r, a, m float64) float64 {
+	x := r / a
+	if x == 0 {
+		return 0
+	}
+
+	return G * m / (12 * a) * (12*x*math.Pow(1+x, 3)*math.Log((1+x)/x) - x/(1+x)*(25+52*x+42*x*x+12*x*x*x))
+}
+
+// NewStars3D creates stars whose masses are drawn from a MassFunction.
+// Output: the stars, and their total mass.
+func NewStars3D(rng *rand.Rand, num_of_stars int, masses MassFunction) (Galaxy3D, float64) {
+	g := make(Galaxy3D, num_of_stars)
+	var total_mass float64
+	for i := range g {
+		var s Star3D
+		s.mass = masses(rng)
+		s.radius = MainSequenceRadius(s.mass)
+		s.red, s.green, s.blue = 255, 255, 255
+		total_mass += s.mass
+		g[i] = &s
+	}
+
+	return g, total_mass
+}
+
+// RandomDirection creates a vector of the given length pointing in a direction drawn uniformly on the sphere.
+func RandomDirection(rng *rand.Rand, length float64) Triple {
+	cos_theta := 2*rng.Float64() - 1
+	sin_theta := math.Sqrt(1 - cos_theta*cos_theta)
+	phi := rng.Float64() * 2 * math.Pi
+
+	return Triple{length * sin_theta * math.Cos(phi), length * sin_theta * math.Sin(phi), length * cos_theta}
+}
+
+// Virialize3D moves a cluster so that its center of mass is at rest at the given center,
+// and then scales its velocities so that twice its kinetic energy equals minus its potential energy.
+func Virialize3D(g Galaxy3D, center Triple) {
+	var com, momentum Triple
+	var total_mass float64
+	for _, s := range g {
+		com = CalculateCOM3D(com, s.position, total_mass, s.mass)
+		momentum = CalculateCOM3D(momentum, s.velocity, total_mass, s.mass)
+		total_mass += s.mass
+	}
+
+	var kinetic, potential float64
+	for i, s := range g {
+		s.position = Triple{s.position.x - com.x + center.x, s.position.y - com.y + center.y, s.position.z - com.z + center.z}
+		s.velocity = Triple{s.velocity.x - momentum.x, s.velocity.y - momentum.y, s.velocity.z - momentum.z}
+		kinetic += 0.5 * s.mass * (s.velocity.x*s.velocity.x + s.velocity.y*s.velocity.y + s.velocity.z*s.velocity.z)
+		for _, other := range g[:i] {
+			potential -= G * s.mass * other.mass / Distance3D(s.position, other.position)
+		}
+	}
+
+	if kinetic > 0 {
+		factor := math.Sqrt(-potential / (2 * kinetic))
+		for _, s := range g {
+			s.velocity = Triple{s.velocity.x * factor, s.velocity.y * factor, s.velocity.z * factor}
+		}
+	}
+}
+
+// PlummerSphere creates a Plummer cluster for the two dimensional simulations.
+// The cluster is drawn as in PlummerSphere3D and projected onto the plane, and the velocities are then scaled
+// again so that it is in virial equilibrium with the forces between stars that all lie in the plane.
+func PlummerSphere(rng *rand.Rand, num_of_stars int, a, max_radius, x, y float64, masses MassFunction) Galaxy {
+	return FlattenGalaxy(PlummerSphere3D(rng, num_of_stars, a, max_radius, x, y, 0, masses))
+}
+
+// HernquistSphere creates a Hernquist galaxy for the two dimensional simulations, in the same way as PlummerSphere.
+func HernquistSphere(rng *rand.Rand, num_of_stars int, a, max_radius, x, y float64, masses MassFunction) Galaxy {
+	return FlattenGalaxy(HernquistSphere3D(rng, num_of_stars, a, max_radius, x, y, 0, masses))
+}
+
+// FlattenGalaxy projects a virialized Galaxy3D onto the z = 0 plane, dropping the z components of the positions
+// and velocities, and scales the velocities so that the flattened galaxy is in virial equilibrium again.
+func FlattenGalaxy(g3 Galaxy3D) Galaxy {
+	g := make(Galaxy, len(g3))
+	for i, s3 := range g3 {
+		s := NewStar(s3.mass)
+		s.radius = s3.radius
+		s.position = OrderedPair{s3.position.x, s3.position.y}
+		s.velocity = OrderedPair{s3.velocity.x, s3.velocity.y}
+		g[i] = s
+	}
+
+	u := Universe{stars: g}
+	kinetic := u.KineticEnergy()
+	if kinetic > 0 {
+		factor := math.Sqrt(-u.PotentialEnergy() / (2 * kinetic))
+		for _, s := range g {
+			s.velocity = OrderedPair{s.velocity.x * factor, s.velocity.y * factor}
+		}
+	}
+
+	return g
+}
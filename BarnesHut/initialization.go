@@ -5,7 +5,7 @@ import (
 	"math/rand"
 )
 
-// InitializeUniverse() sets an initial universe given a collection of galaxies and a width.
+// InitializeUniverse() sets an initial universe given a collection of galaxies and a width, and numbers its stars.
 // It returns a pointer to the resulting universe.
 func InitializeUniverse(galaxies []Galaxy, w float64) *Universe {
 	var u Universe
@@ -16,25 +16,44 @@ func InitializeUniverse(galaxies []Galaxy, w float64) *Universe {
 			u.stars = append(u.stars, b)
 		}
 	}
+	u.AssignIDs()
 	return &u
 }
 
+// AssignIDs gives every star of a universe that has no id yet the next id after the largest one in use,
+// in the order of the stars, so the ids of a universe built from galaxies are 1, 2, 3, ...
+func (u *Universe) AssignIDs() {
+	next := 0
+	for _, s := range u.stars {
+		if s.id > next {
+			next = s.id
+		}
+	}
+	for _, s := range u.stars {
+		if s.id == 0 {
+			next++
+			s.id = next
+		}
+	}
+}
+
 // InitializeGalaxy takes number of stars in the galaxy, radius of the galaxy to be constructed,
 // and center of galaxy to be constructed. Returns a spinning Galaxy object -- which is just a slice of Star pointers
-func InitializeGalaxy(num_of_stars int, r, x, y float64) Galaxy {
+// The positions are drawn from rng, so the same seed always gives the same galaxy.
+func InitializeGalaxy(rng *rand.Rand, num_of_stars int, r, x, y float64) Galaxy {
 	g := make(Galaxy, num_of_stars)
 
 	for i := range g {
 		var s Star
 
 		// First choose distance to center of galaxy
-		dist := (rand.Float64() + 1.0) / 2.0
+		dist := (rng.Float64() + 1.0) / 2.0
 
 		// multiply by factor of r
 		dist *= r
 
 		// Next choose the angle in radians to represent the rotation
-		angle := rand.Float64() * 2 * math.Pi
+		angle := rng.Float64() * 2 * math.Pi
 
 		// convert polar coordinates to Cartesian
 		s.position.x = x + dist*math.Cos(angle)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// InitializeUniverse3D sets an initial three dimensional universe given a collection of galaxies and a width.
+// It returns a pointer to the resulting universe.
+func InitializeUniverse3D(galaxies []Galaxy3D, w float64) *Universe3D {
+	var u Universe3D
+	u.width = w
+	u.stars = make([]*Star3D, 0)
+	for i := range galaxies {
+		u.stars = append(u.stars, galaxies[i]...)
+	}
+	return &u
+}
+
+// InitializeGalaxy3D is the three dimensional counterpart of InitializeGalaxy.
+// The stars are placed in the same annulus in the z = 0 plane around (x, y, z), and are then displaced
+// perpendicular to the disk following an exponential profile with the given scale height.
+// Returns a spinning Galaxy3D with a black hole at its center.
+func InitializeGalaxy3D(rng *rand.Rand, num_of_stars int, r, scale_height, x, y, z float64) Galaxy3D {
+	g := make(Galaxy3D, num_of_stars)
+
+	for i := range g {
+		var s Star3D
+
+		// choose distance to center of galaxy and the angle of rotation, as in InitializeGalaxy
+		dist := (rng.Float64() + 1.0) / 2.0 * r
+		angle := rng.Float64() * 2 * math.Pi
+
+		// choose the height above or below the disk
+		height := rng.ExpFloat64() * scale_height
+		if rng.Intn(2) == 0 {
+			height = -height
+		}
+
+		s.position.x = x + dist*math.Cos(angle)
+		s.position.y = y + dist*math.Sin(angle)
+		s.position.z = z + height
+
+		s.mass = solar_mass
+		s.radius = 696340000
+		s.red, s.green, s.blue = 255, 255, 255
+
+		// spin the galaxy in the plane of the disk
+		speed := 0.5 * math.Sqrt(G*blackhole_mass/dist)
+		s.velocity.x = speed * math.Cos(angle+math.Pi/2.0)
+		s.velocity.y = speed * math.Sin(angle+math.Pi/2.0)
+
+		g[i] = &s
+	}
+
+	//add a blackhole to the center of the galaxy
+	var blackhole Star3D
+	blackhole.mass = blackhole_mass
+	blackhole.position = Triple{x, y, z}
+	blackhole.blue = 255
+	blackhole.radius = 6963400000
+
+	g = append(g, &blackhole)
+
+	return g
+}
+
+// Push3D pushes the galaxy to an assigned direction.
+func Push3D(g *Galaxy3D, v Triple) {
+	for _, s := range *g {
+		s.velocity.x += v.x
+		s.velocity.y += v.y
+		s.velocity.z += v.z
+	}
+}
+
+// Incline3D tilts the disk of a galaxy about the x axis through its center of mass.
+// Both the positions and the velocities are rotated, so the galaxy keeps spinning in its new plane.
+// Input: a galaxy and an inclination in degrees.
+// Output: None.
+func Incline3D(g *Galaxy3D, inclination float64) {
+	var center Triple
+	var total_mass float64
+	for _, s := range *g {
+		center = CalculateCOM3D(center, s.position, total_mass, s.mass)
+		total_mass += s.mass
+	}
+
+	angle := inclination * math.Pi / 180
+	for _, s := range *g {
+		dy := s.position.y - center.y
+		dz := s.position.z - center.z
+		s.position.y = center.y + dy*math.Cos(angle) - dz*math.Sin(angle)
+		s.position.z = center.z + dy*math.Sin(angle) + dz*math.Cos(angle)
+		vy := s.velocity.y
+		vz := s.velocity.z
+		s.velocity.y = vy*math.Cos(angle) - vz*math.Sin(angle)
+		s.velocity.z = vy*math.Sin(angle) + vz*math.Cos(angle)
+	}
+}
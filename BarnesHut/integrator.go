@@ -0,0 +1,82 @@
+package main
+
+// Integrator advances every star of a Universe by a single time step, using a ForceFunction
+// whenever the accelerations must be recomputed.
+// The Universe is updated in place, and each star's acceleration is expected to hold
+// the acceleration at its current position when the step begins.
+type Integrator func(u *Universe, time float64, forces ForceFunction)
+
+// ValidIntegrator checks whether an integration scheme with the given name exists.
+func ValidIntegrator(name string) bool {
+	switch name {
+	case "euler", "leapfrog", "verlet", "adaptive", "block":
+		return true
+	}
+
+	return false
+}
+
+// GetIntegrator looks up an integration scheme by name.
+// Input: the name of the scheme ("euler", "leapfrog", "verlet", "adaptive" or "block").
+// Output: the corresponding Integrator.
+func GetIntegrator(name string) Integrator {
+	switch name {
+	case "euler":
+		return EulerStep
+	case "leapfrog":
+		return LeapfrogStep
+	case "verlet":
+		return VerletStep
+	case "adaptive":
+		return AdaptiveStep(default_eta)
+	case "block":
+		return BlockStep(default_eta)
+	}
+	panic("Error: unknown integrator " + name + ".")
+}
+
+// EulerStep is the original first-order scheme: the acceleration at the current positions is
+// applied to both the velocity and the position.
+// Input: a Universe object, a time step and a force function.
+// Output: None.
+func EulerStep(u *Universe, time float64, forces ForceFunction) {
+	forces(u, u.stars)
+	for _, s := range u.stars {
+		s.velocity = s.UpdateVelocity(time)
+		s.position = s.UpdatePosition(time)
+	}
+}
+
+// LeapfrogStep is the kick-drift-kick leapfrog scheme: half a kick with the old acceleration,
+// a full drift with the half-step velocity, and half a kick with the new acceleration.
+// Input: a Universe object, a time step and a force function.
+// Output: None.
+func LeapfrogStep(u *Universe, time float64, forces ForceFunction) {
+	for _, s := range u.stars {
+		s.velocity = s.UpdateVelocity(time / 2)
+		s.position.x += s.velocity.x * time
+		s.position.y += s.velocity.y * time
+	}
+	forces(u, u.stars)
+	for _, s := range u.stars {
+		s.velocity = s.UpdateVelocity(time / 2)
+	}
+}
+
+// VerletStep is the velocity Verlet scheme: the position is advanced with the old acceleration,
+// then the velocity is advanced with the average of the old and the new accelerations.
+// Input: a Universe object, a time step and a force function.
+// Output: None.
+func VerletStep(u *Universe, time float64, forces ForceFunction) {
+	old_accelerations := make([]OrderedPair, len(u.stars))
+	for i, s := range u.stars {
+		old_accelerations[i] = s.acceleration
+		s.position.x += s.velocity.x*time + s.acceleration.x*time*time/2
+		s.position.y += s.velocity.y*time + s.acceleration.y*time*time/2
+	}
+	forces(u, u.stars)
+	for i, s := range u.stars {
+		s.velocity.x += (old_accelerations[i].x + s.acceleration.x) * time / 2
+		s.velocity.y += (old_accelerations[i].y + s.acceleration.y) * time / 2
+	}
+}
@@ -1,146 +1,226 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"gifhelper"
-	"math"
+	"image/png"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 func main() {
-	runtime.GOMAXPROCS(1)
-	mode := os.Args[1]
-	if mode == "galaxy" {
-		GalaxySimulation()
-	} else if mode == "jupiter" {
-		JupiterSimulation()
-	} else {
-		CollisionSimulation()
+	// the first interrupt stops the run cleanly, after which the default handling comes back, so a second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := RunCommand(ctx, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Stopped:", err)
+		os.Exit(130)
+	}
+	var usage_error UsageError
+	if errors.As(err, &usage_error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		usage_error.PrintUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
 
-func JupiterSimulation() {
-	var jupiter, io, europa, ganymede, callisto Star
-
-	jupiter.red, jupiter.green, jupiter.blue = 223, 227, 202
-	io.red, io.green, io.blue = 249, 249, 165
-	europa.red, europa.green, europa.blue = 132, 83, 52
-	ganymede.red, ganymede.green, ganymede.blue = 76, 0, 153
-	callisto.red, callisto.green, callisto.blue = 0, 153, 76
-
-	jupiter.mass = 1.898 * math.Pow(10, 27)
-	io.mass = 8.9319 * math.Pow(10, 22)
-	europa.mass = 4.7998 * math.Pow(10, 22)
-	ganymede.mass = 1.4819 * math.Pow(10, 23)
-	callisto.mass = 1.0759 * math.Pow(10, 23)
-
-	jupiter.radius = 71000000
-	io.radius = 1821000
-	europa.radius = 1569000
-	ganymede.radius = 2631000
-	callisto.radius = 2410000
-
-	jupiter.position.x, jupiter.position.y = 2000000000, 2000000000
-	io.position.x, io.position.y = 2000000000-421600000, 2000000000
-	europa.position.x, europa.position.y = 2000000000, 2000000000+670900000
-	ganymede.position.x, ganymede.position.y = 2000000000+1070400000, 2000000000
-	callisto.position.x, callisto.position.y = 2000000000, 2000000000-1882700000
-
-	jupiter.velocity.x, jupiter.velocity.y = 0, 0
-	io.velocity.x, io.velocity.y = 0, -17320
-	europa.velocity.x, europa.velocity.y = -13740, 0
-	ganymede.velocity.x, ganymede.velocity.y = 0, 10870
-	callisto.velocity.x, callisto.velocity.y = 8200, 0
-
-	// declaring universe and setting its fields.
-	var jupiter_system Universe
-	jupiter_system.width = 4000000000
-	jupiter_system.stars = make([]*Star, 5)
-	jupiter_system.stars[0] = (&jupiter).CopyStar()
-	jupiter_system.stars[1] = (&io).CopyStar()
-	jupiter_system.stars[2] = (&europa).CopyStar()
-	jupiter_system.stars[3] = (&ganymede).CopyStar()
-	jupiter_system.stars[4] = (&callisto).CopyStar()
-
-	var num_gens int = 1000000
-	var time float64 = 1.0
-	var canvas_width int = 500
-	var drawing_frequency int = 1000
-	var theta float64 = 0.5
-	var scaling_factor float64 = 5
-
-	fmt.Println("Command line arguments read successfully.")
-
-	fmt.Println("Simulating system.")
-
-	time_points := BarnesHut(&jupiter_system, num_gens, time, theta)
-
-	fmt.Println("Gravity has been simulated!")
-	fmt.Println("Ready to draw images.")
-
-	images := AnimateSystem(time_points, canvas_width, drawing_frequency, scaling_factor)
-
-	fmt.Println("Images drawn!")
+// RunCommand runs the subcommand named by the first argument with the rest of the arguments.
+// Input: a context cancelling the simulation of run and resume, and the command line arguments, without the name of the program.
+// Output: a UsageError if the command line could not be understood, or the error that stopped the command.
+func RunCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return UsageError{message: "no command given"}
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Print(usage)
+		return nil
+	}
 
-	fmt.Println("Making GIF.")
+	opts, filename, err := ParseCommand(args[0], args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// print the usage of the subcommand, this time to the standard output
+		fs := NewCommandFlags(args[0], &RunOptions{})
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-	gifhelper.ImagesToGIF(images, "jupiter")
+	switch args[0] {
+	case "run":
+		return ScenarioSimulation(ctx, filename, opts)
+	case "resume":
+		return ResumeSimulation(ctx, filename, opts)
+	case "render":
+		return RenderSnapshot(filename, opts)
+	default:
+		return AnalyzeSimulation(filename, opts)
+	}
+}
 
-	fmt.Println("Animated GIF produced!")
+// ScenarioFile finds the file of a scenario: the name of a scenario shipped in the scenarios directory
+// stands for its file, and anything else is taken as a file name.
+func ScenarioFile(name string) string {
+	if !strings.ContainsAny(name, "./"+string(filepath.Separator)) {
+		return filepath.Join("scenarios", name+".json")
+	}
 
-	fmt.Println("Exiting normally.")
+	return name
 }
 
-func GalaxySimulation() {
-	g0 := InitializeGalaxy(500, 4e21, 5e22, 4e22)
-	width := 1.0e23
-	galaxies := []Galaxy{g0}
-
-	initial_universe := InitializeUniverse(galaxies, width)
+// ScenarioSimulation runs a scenario with the settings given on the command line.
+// Usage: run [flags] <scenario>
+func ScenarioSimulation(ctx context.Context, filename string, opts *RunOptions) error {
+	sc, err := LoadScenario(ScenarioFile(filename))
+	if err != nil {
+		return fmt.Errorf("invalid scenario %s:\n%w", filename, err)
+	}
+	opts.Apply(sc)
+	if err := sc.Validate(); err != nil {
+		return UsageError{fmt.Sprintf("invalid settings for %s:\n%v", filename, err), NewCommandFlags("run", &RunOptions{})}
+	}
 
-	var num_gens int = 50000
-	var time float64 = 2e14
-	var theta float64 = 0.5
-	var canvas_width int = 1000
-	var drawing_frequency int = 1000
-	var scaling_factor float64 = 1e11 // a scaling factor is needed to inflate size of stars when drawn because galaxies are very sparse
+	return RunScenario(ctx, sc, opts.progress)
+}
 
-	time_points := BarnesHut(initial_universe, num_gens, time, theta)
+// ResumeSimulation continues a run from a snapshot written by SaveSnapshot, with the settings of a scenario
+// or, without one, those given on the command line and the integrator of the snapshot.
+// Snapshots of universes without an integrator resume with leapfrog.
+// Usage: resume [flags] <snapshot>
+func ResumeSimulation(ctx context.Context, filename string, opts *RunOptions) error {
+	var sc *Scenario
+	if opts.scenario != "" {
+		loaded, err := LoadScenario(ScenarioFile(opts.scenario))
+		if err != nil {
+			return fmt.Errorf("invalid scenario %s:\n%w", opts.scenario, err)
+		}
+		sc = loaded
+	} else {
+		if !opts.set["dt"] || !opts.set["generations"] {
+			return UsageError{"resume needs -scenario, or -dt and -generations", NewCommandFlags("resume", &RunOptions{})}
+		}
+		sc = &Scenario{
+			Integration: IntegrationOptions{Theta: opts.theta, Integrator: "leapfrog", NumProcs: runtime.NumCPU()},
+			Rendering:   RenderingOptions{CanvasWidth: opts.canvas_width, DrawingFrequency: opts.drawing_frequency, ScalingFactor: opts.scaling_factor},
+		}
+	}
+	opts.Apply(sc)
+	sc.Name = opts.output
+	if err := sc.ValidateSettings(); err != nil {
+		return UsageError{fmt.Sprintf("invalid settings for resuming %s:\n%v", filename, err), NewCommandFlags("resume", &RunOptions{})}
+	}
 
-	fmt.Println("Simulation run. Now drawing images.")
-	image_list := AnimateSystem(time_points, canvas_width, drawing_frequency, scaling_factor)
+	initial_universe, generation, err := LoadSnapshot(filename)
+	if err != nil {
+		return err
+	}
+	// the policies are those the snapshot was written with, and so is the integrator unless a scenario chooses one
+	sc.EscapePolicy, sc.CollisionPolicy = initial_universe.escape_policy, initial_universe.collision_policy
+	if opts.scenario == "" && initial_universe.integrator != "" {
+		sc.Integration.Integrator = initial_universe.integrator
+	}
+	fmt.Println("Resuming from generation", generation, "of", filename)
 
-	fmt.Println("Images drawn. Now generating GIF.")
-	gifhelper.ImagesToGIF(image_list, "galaxy")
-	fmt.Println("GIF drawn.")
+	return RunScenarioFrom(ctx, sc, initial_universe, generation, opts.progress)
 }
 
-func CollisionSimulation() {
-	g0 := InitializeGalaxy(500, 4e21, 5e22, 4e22)
-	g1 := InitializeGalaxy(500, 4e21, 4e22, 4e22)
+// RenderSnapshot draws the whole universe of a snapshot to <name>.png.
+// Usage: render [flags] <snapshot>
+func RenderSnapshot(filename string, opts *RunOptions) error {
+	u, generation, err := LoadSnapshot(filename)
+	if err != nil {
+		return err
+	}
+	name := opts.output
+	if name == "" {
+		name = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
 
-	Push(&g0, OrderedPair{-100, 200})
-	Push(&g1, OrderedPair{200, -100})
+	style := RenderStyle{mode: opts.mode, colormap: opts.colormap, tree_overlay: opts.overlay}
+	file, err := os.Create(name + ".png")
+	if err != nil {
+		return err
+	}
+	err = png.Encode(file, u.DrawViewToCanvas(opts.canvas_width, opts.scaling_factor, u.FullView(), style, nil, 0))
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Drew generation", generation, "of", filename, "to", name+".png")
 
-	width := 1.0e23
-	galaxies := []Galaxy{g0, g1}
+	return nil
+}
 
-	initial_universe := InitializeUniverse(galaxies, width)
+// AnalyzeSimulation prints the conserved quantities of the initial universe of a scenario, or of a snapshot,
+// and compares the tree walk against direct summation for several values of theta.
+// The comparison is also written to <name>.accuracy.csv.
+// Usage: analyze [flags] <scenario>
+func AnalyzeSimulation(filename string, opts *RunOptions) error {
+	var u *Universe
+	var generation int
+	num_procs := runtime.NumCPU()
+	name := opts.output
+	if opts.snapshot {
+		loaded, loaded_generation, err := LoadSnapshot(filename)
+		if err != nil {
+			return err
+		}
+		u, generation = loaded, loaded_generation
+		if name == "" {
+			name = strings.TrimSuffix(filename, filepath.Ext(filename))
+		}
+	} else {
+		sc, err := LoadScenario(ScenarioFile(filename))
+		if err != nil {
+			return fmt.Errorf("invalid scenario %s:\n%w", filename, err)
+		}
+		if sc.Dimensions == 3 {
+			return fmt.Errorf("%s has three dimensions, but only two dimensional scenarios can be analyzed", filename)
+		}
+		opts.Apply(sc)
+		u = sc.BuildUniverse()
+		num_procs = sc.Integration.NumProcs
+		if name == "" {
+			name = sc.Name
+		}
+	}
 
-	var num_gens int = 12000
-	var time float64 = 2e15
-	var theta float64 = 0.5
-	var canvas_width int = 800
-	var draw_frequency int = 300
-	var scaling_factor float64 = 1e11 // a scaling factor is needed to inflate size of stars when drawn because galaxies are very sparse
+	d := u.ComputeDiagnostics(generation, 0.5)
+	fmt.Println(len(u.stars), "stars at generation", generation)
+	fmt.Printf("kinetic energy\t\t%.6e\n", d.kinetic)
+	fmt.Printf("potential energy\t%.6e\n", d.potential_exact)
+	fmt.Printf("total energy\t\t%.6e\n", d.kinetic+d.potential_exact)
+	fmt.Printf("linear momentum\t\t(%.6e, %.6e)\n", d.momentum.x, d.momentum.y)
+	fmt.Printf("angular momentum\t%.6e\n\n", d.angular_momentum)
 
-	time_points := BarnesHut(initial_universe, num_gens, time, theta)
+	thetas := []float64{0.1, 0.25, 0.5, 0.75, 1.0}
+	reports := CompareAccuracy(u, thetas, num_procs)
 
-	fmt.Println("Simulation run. Now drawing images.")
-	image_list := AnimateSystem(time_points, canvas_width, draw_frequency, scaling_factor)
+	fmt.Println("theta\tmedian\t\tp99\t\tmax\t\tspeedup")
+	for _, r := range reports {
+		fmt.Printf("%.2f\t%.3e\t%.3e\t%.3e\t%.1fx\n", r.theta, r.median, r.percentile, r.max, r.Speedup())
+	}
 
-	fmt.Println("Images drawn. Now generating GIF.")
-	gifhelper.ImagesToGIF(image_list, "collision")
-	fmt.Println("GIF drawn.")
+	return WriteAccuracyReport(reports, name+".accuracy.csv")
 }
@@ -0,0 +1,78 @@
+package main
+
+// Quadrupole holds the independent components of the traceless quadrupole moment of a node
+// about its center of mass, Q_ij = sum of m * (3 d_i d_j - |d|^2 delta_ij). Since every star lies in
+// the plane, the zz component never contributes to the force on another star and is not stored.
+type Quadrupole struct {
+	xx, xy, yy float64
+}
+
+// UpdateQuadrupole accumulates the quadrupole moments of all internal nodes, after UpdateDummyStar
+// has set their centers of mass. The moment of a node is the sum of the moments of its children,
+// each shifted from the center of mass of the child to that of the node.
+// Input: the root of the tree.
+// Output: None.
+func UpdateQuadrupole(n *Node) {
+	if n.children == nil {
+		return
+	}
+
+	n.quadrupole = Quadrupole{}
+	for _, child := range n.children {
+		if child.star == nil {
+			continue
+		}
+		UpdateQuadrupole(child)
+		dx := child.star.position.x - n.star.position.x
+		dy := child.star.position.y - n.star.position.y
+		n.quadrupole.AddPoint(dx, dy, child.star.mass, child.quadrupole)
+	}
+}
+
+// AddPoint adds to a quadrupole moment a mass at offset (dx, dy) from the center of mass,
+// together with the quadrupole moment of that mass about its own center.
+func (q *Quadrupole) AddPoint(dx, dy, m float64, inner Quadrupole) {
+	q.xx += inner.xx + m*(2*dx*dx-dy*dy)
+	q.xy += inner.xy + m*3*dx*dy
+	q.yy += inner.yy + m*(2*dy*dy-dx*dx)
+}
+
+// ComputeQuadrupoleForce computes the correction to the monopole force of a node on star s
+// that comes from the quadrupole moment of the node.
+// Input: an internal node whose dummy star and quadrupole are up to date.
+// Output: the quadrupole part of the force acting on star s.
+func (s *Star) ComputeQuadrupoleForce(n *Node) OrderedPair {
+	return s.QuadrupoleForce(n.star.position, n.quadrupole)
+}
+
+// QuadrupoleForce computes the force on star s of a quadrupole moment q about the center of mass com.
+func (s *Star) QuadrupoleForce(com OrderedPair, q Quadrupole) OrderedPair {
+	var force OrderedPair
+
+	// r points from the center of mass of the node to the star
+	rx := s.position.x - com.x
+	ry := s.position.y - com.y
+	r2 := rx*rx + ry*ry
+	r5 := r2 * r2 * Distance(s.position, com)
+
+	qx := q.xx*rx + q.xy*ry
+	qy := q.xy*rx + q.yy*ry
+	rqr := rx*qx + ry*qy
+
+	force.x = G * s.mass * (qx - 2.5*rqr*rx/r2) / r5
+	force.y = G * s.mass * (qy - 2.5*rqr*ry/r2) / r5
+
+	return force
+}
+
+// ComputeQuadrupolePotential computes the correction to the monopole potential energy of star s
+// and a node that comes from the quadrupole moment of the node.
+func (s *Star) ComputeQuadrupolePotential(n *Node) float64 {
+	rx := s.position.x - n.star.position.x
+	ry := s.position.y - n.star.position.y
+	r2 := rx*rx + ry*ry
+	r5 := r2 * r2 * Distance(s.position, n.star.position)
+	rqr := rx*(n.quadrupole.xx*rx+n.quadrupole.xy*ry) + ry*(n.quadrupole.xy*rx+n.quadrupole.yy*ry)
+
+	return -G * s.mass * rqr / (2 * r5)
+}
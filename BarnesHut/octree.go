@@ -0,0 +1,227 @@
+package main
+
+import "math"
+
+// OctTree simply contains a pointer to the root, and the softening of the universe it was built from.
+type OctTree struct {
+	root      *OctNode
+	softening Softening
+}
+
+// OctNode is the three dimensional counterpart of Node, with eight children instead of four.
+// Every internal node points to a dummy star holding the center of mass and the total mass of its children.
+type OctNode struct {
+	children []*OctNode
+	star     *Star3D
+	sector   Octant
+}
+
+// Octant is an object representing a sub-cube within a larger universe.
+type Octant struct {
+	x     float64 //corner x coordinate (smallest x in the cube)
+	y     float64 //corner y coordinate (smallest y in the cube)
+	z     float64 //corner z coordinate (smallest z in the cube)
+	width float64
+}
+
+// BuildOctTree creates a new octree from the stars of a Universe3D and updates its dummy stars.
+// Input: a Universe3D object.
+// Output: an octree ready for the tree walk.
+func (u *Universe3D) BuildOctTree() *OctTree {
+	ot := ConstructOctTree(u.stars, u.width)
+	UpdateDummyStar3D(ot.root)
+	ot.softening = u.softening
+
+	return ot
+}
+
+// ConstructOctTree creates a new octree based on a series of stars.
+// The root of the tree is the smallest cube around the stars, so stars that left the universe are still sorted properly.
+// Input: a slice of stars and the width of the Universe3D.
+// Output: an octree.
+func ConstructOctTree(stars []*Star3D, width float64) *OctTree {
+	ot := InitializeOctTreeInSector(BoundingOctant(stars, width))
+	ot.Insert(stars)
+
+	return ot
+}
+
+// InitializeOctTree initializes an octree that contains a root and eight empty children.
+// Input: the width of the Universe3D.
+// Output: an octree.
+func InitializeOctTree(w float64) *OctTree {
+	return InitializeOctTreeInSector(Octant{x: 0, y: 0, z: -w / 2, width: w})
+}
+
+// InitializeOctTreeInSector initializes an octree whose root covers the given sector.
+// Input: the sector of the root.
+// Output: an octree.
+func InitializeOctTreeInSector(sector Octant) *OctTree {
+	var t OctTree
+	var r OctNode
+	r.star = &Star3D{}
+	r.sector = sector
+	t.root = &r
+	t.root.Split()
+
+	return &t
+}
+
+// BoundingOctant computes the smallest cube containing every star, the three dimensional counterpart of BoundingSector.
+// The cube is padded slightly so that the stars on its far faces are strictly inside.
+// Input: a slice of stars and the width of the Universe3D.
+// Output: the sector to use as the root of an octree.
+func BoundingOctant(stars []*Star3D, width float64) Octant {
+	if len(stars) == 0 {
+		return Octant{x: 0, y: 0, z: -width / 2, width: width}
+	}
+
+	low, high := stars[0].position, stars[0].position
+	for _, s := range stars {
+		low = Triple{math.Min(low.x, s.position.x), math.Min(low.y, s.position.y), math.Min(low.z, s.position.z)}
+		high = Triple{math.Max(high.x, s.position.x), math.Max(high.y, s.position.y), math.Max(high.z, s.position.z)}
+	}
+
+	extent := math.Max(high.x-low.x, math.Max(high.y-low.y, high.z-low.z))
+	if extent == 0 {
+		// a single star (or stars all at one point) still needs a sector of some size
+		extent = width
+	}
+	// the padding must also exceed the rounding error of the coordinates themselves
+	magnitude := math.Max(math.Max(math.Abs(low.x), math.Abs(high.x)), math.Max(math.Max(math.Abs(low.y), math.Abs(high.y)), math.Max(math.Abs(low.z), math.Abs(high.z))))
+	pad := 1e-6 * math.Max(extent, magnitude)
+
+	return Octant{x: low.x - pad, y: low.y - pad, z: low.z - pad, width: extent + 2*pad}
+}
+
+// Insert inserts stars one by one to the octree based on octant.
+// Like the quadtree, it splits at most max_quadtree_depth levels below the root and keeps the stars still sharing
+// an octant there side by side in a bucket.
+// Input: an initialized octree and a slice of stars.
+// Output: None.
+func (ot *OctTree) Insert(stars []*Star3D) {
+	for i := range stars {
+		if !ot.root.InField(stars[i]) {
+			panic("Error: star outside of the root sector in Insert.")
+		}
+		next := ot.root.children[InWhichOctant(stars[i].position, ot.root)]
+		depth := 1
+		bucketed := false
+
+		for next.star != nil {
+			if depth == max_quadtree_depth {
+				// the octant cannot be split any further --> keep the stars side by side in a bucket
+				if next.children == nil {
+					next.children = []*OctNode{{star: next.star, sector: next.sector}}
+					next.star = &Star3D{}
+				}
+				next.children = append(next.children, &OctNode{star: stars[i], sector: next.sector})
+				bucketed = true
+				break
+			}
+			// the position that is going to be inserted already has another star
+			if next.children == nil {
+				// the leaf becomes an internal node: move its star one level down and give it a dummy star
+				next.Split()
+				next.children[InWhichOctant(next.star.position, next)].star = next.star
+				next.star = &Star3D{}
+			}
+			// traverse down
+			next = next.children[InWhichOctant(stars[i].position, next)]
+			depth++
+		}
+		if !bucketed {
+			next.star = stars[i]
+		}
+	}
+}
+
+// UpdateDummyStar3D updates the positions and the masses of internal nodes, which have not been processed in Insert().
+// Input: the root of the tree.
+// Output: the position and the mass of the internal node (dummy star).
+func UpdateDummyStar3D(n *OctNode) (Triple, float64) {
+	if n.children != nil {
+		// this node is an internal node
+		n.star.position = Triple{}
+		n.star.mass = 0
+		for i := range n.children {
+			// range over all its children to calculate the center of mass and the final mass
+			if n.children[i].star != nil {
+				position, mass := UpdateDummyStar3D(n.children[i])
+				n.star.position = CalculateCOM3D(n.star.position, position, n.star.mass, mass)
+				n.star.mass += mass
+			}
+		}
+	}
+
+	return n.star.position, n.star.mass
+}
+
+// CalculateCOM3D calculates the center of mass of two stars in three dimensions.
+// Input: positions and masses of two stars.
+// Output: a center of mass (Triple).
+func CalculateCOM3D(p1, p2 Triple, m1, m2 float64) Triple {
+	var COM_position Triple
+	mass_sum := m1 + m2
+	COM_position.x = (p1.x*m1 + p2.x*m2) / mass_sum
+	COM_position.y = (p1.y*m1 + p2.y*m2) / mass_sum
+	COM_position.z = (p1.z*m1 + p2.z*m2) / mass_sum
+
+	return COM_position
+}
+
+// Split creates eight empty children of the node and assigns the sectors.
+// The index of a child has bit 0 set for the upper half in x, bit 1 for y and bit 2 for z.
+// Input: a node.
+// Output: None
+func (n *OctNode) Split() {
+	if n.children != nil {
+		return
+	}
+
+	new_width := n.sector.width * 0.5
+	n.children = make([]*OctNode, 8)
+	for i := range n.children {
+		var child OctNode
+		child.sector = Octant{x: n.sector.x, y: n.sector.y, z: n.sector.z, width: new_width}
+		if i&1 != 0 {
+			child.sector.x += new_width
+		}
+		if i&2 != 0 {
+			child.sector.y += new_width
+		}
+		if i&4 != 0 {
+			child.sector.z += new_width
+		}
+		n.children[i] = &child
+	}
+}
+
+// InWhichOctant determines the octant of the star based on its position in the Universe3D.
+// Input: a position of a star and its parent node.
+// Output: an int represents the octant, using the same numbering as Split.
+func InWhichOctant(p Triple, n *OctNode) int {
+	half_width := n.sector.width * 0.5
+	octant := 0
+
+	if p.x >= n.sector.x+half_width {
+		octant |= 1
+	}
+	if p.y >= n.sector.y+half_width {
+		octant |= 2
+	}
+	if p.z >= n.sector.z+half_width {
+		octant |= 4
+	}
+
+	return octant
+}
+
+// InField makes sure the star is inside the sector of the node.
+// Input: a star and a root node.
+// Output: boolean that represents the star is inside or outside the sector.
+func (n *OctNode) InField(s *Star3D) bool {
+	return s.position.x >= n.sector.x && s.position.x <= n.sector.x+n.sector.width &&
+		s.position.y >= n.sector.y && s.position.y <= n.sector.y+n.sector.width &&
+		s.position.z >= n.sector.z && s.position.z <= n.sector.z+n.sector.width
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"strconv"
+)
+
+// OrbitalElements describe the Keplerian orbit of a star around a primary. The argument of periapsis
+// is the angle of the periapsis from the x axis, in radians. Unbound orbits have an infinite period.
+type OrbitalElements struct {
+	semi_major_axis    float64
+	eccentricity       float64
+	period             float64
+	periapsis_argument float64
+}
+
+// OrbitRecord holds the orbital elements of one star at one generation.
+type OrbitRecord struct {
+	generation int
+	time       float64
+	id         int
+	elements   OrbitalElements
+}
+
+// OrbitAnalysis follows the orbits of some stars around a primary star while a simulation runs.
+// Besides the elements at every sampled generation, it accumulates the angle every star has swept around
+// the primary, from which MeasuredPeriod finds the actual orbital period.
+type OrbitAnalysis struct {
+	primary    int
+	ids        []int
+	records    []OrbitRecord
+	swept      map[int]float64
+	last_angle map[int]float64
+	first      map[int]float64
+	last       map[int]float64
+}
+
+// PeriodReport compares the measured orbital period of a star with its known value, both in seconds.
+type PeriodReport struct {
+	name     string
+	id       int
+	measured float64
+	known    float64
+}
+
+// NewOrbitAnalysis prepares to follow the stars with the given ids around the star with id primary.
+func NewOrbitAnalysis(primary int, ids []int) *OrbitAnalysis {
+	return &OrbitAnalysis{
+		primary:    primary,
+		ids:        ids,
+		records:    make([]OrbitRecord, 0),
+		swept:      make(map[int]float64),
+		last_angle: make(map[int]float64),
+		first:      make(map[int]float64),
+		last:       make(map[int]float64),
+	}
+}
+
+// ComputeOrbitalElements converts the position and velocity of star s relative to a primary into orbital elements.
+// Input: the orbiting star and its primary.
+// Output: the elements of the two-body orbit, using the sum of both masses.
+func (s *Star) ComputeOrbitalElements(primary *Star) OrbitalElements {
+	var elements OrbitalElements
+	mu := G * (s.mass + primary.mass)
+	rx, ry := s.position.x-primary.position.x, s.position.y-primary.position.y
+	vx, vy := s.velocity.x-primary.velocity.x, s.velocity.y-primary.velocity.y
+	r := math.Hypot(rx, ry)
+	v2 := vx*vx + vy*vy
+
+	// the eccentricity vector points at the periapsis
+	rv := rx*vx + ry*vy
+	ex := ((v2-mu/r)*rx - rv*vx) / mu
+	ey := ((v2-mu/r)*ry - rv*vy) / mu
+	elements.eccentricity = math.Hypot(ex, ey)
+	elements.periapsis_argument = math.Atan2(ey, ex)
+
+	energy := v2/2 - mu/r
+	if energy < 0 {
+		elements.semi_major_axis = -mu / (2 * energy)
+		elements.period = 2 * math.Pi * math.Sqrt(math.Pow(elements.semi_major_axis, 3)/mu)
+	} else {
+		elements.semi_major_axis = math.Inf(1)
+		elements.period = math.Inf(1)
+	}
+
+	return elements
+}
+
+// OrbitObserver creates an Observer that records the orbital elements of the followed stars every frequency generations,
+// and accumulates the angle they sweep around the primary. The frequency must be small enough that no star
+// goes half way around its orbit between two samples.
+func OrbitObserver(analysis *OrbitAnalysis, frequency int) Observer {
+	return func(generation int, u *Universe) {
+		if generation%frequency != 0 {
+			return
+		}
+		stars := make(map[int]*Star, len(u.stars))
+		for _, s := range u.stars {
+			stars[s.id] = s
+		}
+		primary, found := stars[analysis.primary]
+		if !found {
+			return
+		}
+
+		for _, id := range analysis.ids {
+			s, found := stars[id]
+			if !found {
+				continue
+			}
+			analysis.records = append(analysis.records, OrbitRecord{generation, u.time, id, s.ComputeOrbitalElements(primary)})
+
+			angle := math.Atan2(s.position.y-primary.position.y, s.position.x-primary.position.x)
+			if _, started := analysis.first[id]; !started {
+				analysis.first[id] = u.time
+			} else {
+				// the change of angle since the last sample, between -pi and pi
+				analysis.swept[id] += math.Remainder(angle-analysis.last_angle[id], 2*math.Pi)
+			}
+			analysis.last_angle[id] = angle
+			analysis.last[id] = u.time
+		}
+	}
+}
+
+// MeasuredPeriod is the time a star takes to sweep a full turn around the primary at its average angular speed.
+// Input: the id of a followed star.
+// Output: the period in seconds, or zero if the star has not moved around the primary yet.
+func (analysis *OrbitAnalysis) MeasuredPeriod(id int) float64 {
+	swept := math.Abs(analysis.swept[id])
+	if swept == 0 {
+		return 0
+	}
+
+	return (analysis.last[id] - analysis.first[id]) * 2 * math.Pi / swept
+}
+
+// RelativeError is how far the measured period is from the known one, as a fraction of the known period.
+func (r PeriodReport) RelativeError() float64 {
+	return math.Abs(r.measured-r.known) / r.known
+}
+
+// WriteOrbits writes the recorded orbital elements to a CSV file, one row per star and sampled generation.
+// Input: an OrbitAnalysis and a file name.
+// Output: an error if the file could not be written.
+func WriteOrbits(analysis *OrbitAnalysis, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"id", "generation", "time", "semi_major_axis", "eccentricity", "period", "periapsis_argument"})
+	for _, r := range analysis.records {
+		w.Write([]string{
+			strconv.Itoa(r.id),
+			strconv.Itoa(r.generation),
+			FormatFloat(r.time),
+			FormatFloat(r.elements.semi_major_axis),
+			FormatFloat(r.elements.eccentricity),
+			FormatFloat(r.elements.period),
+			FormatFloat(r.elements.periapsis_argument),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// WritePeriodReport writes measured and known periods to a CSV file.
+// Input: a slice of PeriodReport and a file name.
+// Output: an error if the file could not be written.
+func WritePeriodReport(reports []PeriodReport, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"name", "id", "measured_period", "known_period", "relative_error"})
+	for _, r := range reports {
+		w.Write([]string{
+			r.name,
+			strconv.Itoa(r.id),
+			FormatFloat(r.measured),
+			FormatFloat(r.known),
+			FormatFloat(r.RelativeError()),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// ewald_table_size is the number of intervals of the correction table along each axis of [0, 1/2].
+const ewald_table_size = 32
+
+// ewald_images is the number of rings of images summed for each entry of the correction table.
+// The sum is repeated with twice as many rings and the two are extrapolated to an infinite tiling.
+const ewald_images = 16
+
+// ewald_table holds the correction forces of a unit box, and ewald_potential_table the corrections to the potential,
+// both computed once on first use.
+var ewald_table [ewald_table_size + 1][ewald_table_size + 1]OrderedPair
+var ewald_potential_table [ewald_table_size + 1][ewald_table_size + 1]float64
+var ewald_once sync.Once
+
+// Periodic reports whether a universe is a periodic box, in which the square [0, width) x [0, width)
+// is tiled infinitely in the plane and stars leaving one side come back on the other.
+// Both the forces and the potential energies of the diagnostics see the periodic images.
+func (u *Universe) Periodic() bool {
+	return u.escape_policy == "periodic"
+}
+
+// Wrap brings a coordinate that has left the periodic box [0, width) back into it.
+func Wrap(p, width float64) float64 {
+	p = math.Mod(p, width)
+	if p < 0 {
+		p += width
+	}
+	if p >= width {
+		// a tiny negative coordinate can round up to width
+		p = 0
+	}
+
+	return p
+}
+
+// NearestImage computes the shortest displacement from p1 to any periodic image of p2.
+// Input: two points and the width of the box.
+// Output: the displacement, with each component between -width/2 and width/2.
+func NearestImage(p1, p2 OrderedPair, width float64) OrderedPair {
+	d := OrderedPair{p2.x - p1.x, p2.y - p1.y}
+	d.x -= width * math.Round(d.x/width)
+	d.y -= width * math.Round(d.y/width)
+
+	return d
+}
+
+// ComputePeriodicNetForce is the counterpart of ComputeNetForce for a periodic box.
+// Every node is seen at its nearest image, by evaluating the force on a copy of s moved next to the node,
+// and the force of all the further images of the node is added from the Ewald correction table.
+// Nodes wider than a quarter of the box are always opened, since their stars may have different nearest images.
+// Input: a quadtree built from a periodic universe and a theta parameter.
+// Output: the net force vector acting on the given star.
+func (s *Star) ComputePeriodicNetForce(qt *QuadTree, theta float64) OrderedPair {
+	var net_force OrderedPair
+	queue := make([]*Node, 1)
+	queue[0] = qt.root
+
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		d := NearestImage(s.position, cur.star.position, qt.period)
+		image := *s
+		image.position = OrderedPair{cur.star.position.x - d.x, cur.star.position.y - d.y}
+
+		if cur.children == nil {
+			if s.IsSelf(cur.star) {
+				continue
+			}
+		} else if cur.sector.width > qt.period/4 || image.CalculateTheta(cur) > theta {
+			for i := range cur.children {
+				if cur.children[i].star != nil {
+					queue = append(queue, cur.children[i])
+				}
+			}
+			continue
+		} else if qt.quadrupole {
+			net_force.AddNewForce(image.ComputeQuadrupoleForce(cur))
+		}
+
+		net_force.AddNewForce(image.ComputeForce(cur.star, qt.softening))
+		correction := EwaldCorrection(d, qt.period)
+		net_force.AddNewForce(OrderedPair{G * s.mass * cur.star.mass * correction.x, G * s.mass * cur.star.mass * correction.y})
+	}
+
+	return net_force
+}
+
+// ComputePeriodicPotential is the counterpart of ComputePotential for a periodic box: the potential energy of star s
+// with the nearest image of another star, plus that of all its further images from the Ewald correction table.
+// Input: another star, the softening and the width of the box.
+// Output: the potential energy.
+func (s *Star) ComputePeriodicPotential(new_star *Star, softening Softening, width float64) float64 {
+	d := NearestImage(s.position, new_star.position, width)
+
+	return -G * s.mass * new_star.mass * (softening.PotentialFactor(math.Hypot(d.x, d.y)) + EwaldPotentialCorrection(d, width))
+}
+
+// ComputePeriodicNetPotential is the counterpart of ComputeNetPotential for a periodic box.
+// It walks the tree exactly as ComputePeriodicNetForce does, so the energy is that of the forces being integrated.
+// Input: a quadtree built from a periodic universe and a theta parameter.
+// Output: the potential energy of the given star.
+func (s *Star) ComputePeriodicNetPotential(qt *QuadTree, theta float64) float64 {
+	var potential float64
+	queue := make([]*Node, 1)
+	queue[0] = qt.root
+
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		d := NearestImage(s.position, cur.star.position, qt.period)
+		image := *s
+		image.position = OrderedPair{cur.star.position.x - d.x, cur.star.position.y - d.y}
+
+		if cur.children == nil {
+			if s.IsSelf(cur.star) {
+				continue
+			}
+		} else if cur.sector.width > qt.period/4 || image.CalculateTheta(cur) > theta {
+			for i := range cur.children {
+				if cur.children[i].star != nil {
+					queue = append(queue, cur.children[i])
+				}
+			}
+			continue
+		} else if qt.quadrupole {
+			potential += image.ComputeQuadrupolePotential(cur)
+		}
+
+		potential += image.ComputePotential(cur.star, qt.softening)
+		potential -= G * s.mass * cur.star.mass * EwaldPotentialCorrection(d, qt.period)
+	}
+
+	return potential
+}
+
+// EwaldCell finds the cell of the correction tables that holds a nearest image displacement, along with the weights
+// of its four corners for bilinear interpolation. The tables cover one quadrant, so only the size of each component counts.
+// Input: a nearest image displacement and the width of the box.
+// Output: the indices of the lower corner of the cell, and the offset and weight of every corner.
+func EwaldCell(d OrderedPair, width float64) (int, int, [4][3]float64) {
+	u := math.Min(math.Abs(d.x)/width, 0.5) * 2 * ewald_table_size
+	v := math.Min(math.Abs(d.y)/width, 0.5) * 2 * ewald_table_size
+	i := int(math.Min(u, ewald_table_size-1))
+	j := int(math.Min(v, ewald_table_size-1))
+	fu, fv := u-float64(i), v-float64(j)
+
+	return i, j, [4][3]float64{{0, 0, (1 - fu) * (1 - fv)}, {1, 0, fu * (1 - fv)}, {0, 1, (1 - fu) * fv}, {1, 1, fu * fv}}
+}
+
+// EwaldCorrection interpolates the force that all images of a unit mass except the nearest one exert
+// on a unit mass at a displacement d from that nearest image, divided by G.
+// Input: a nearest image displacement and the width of the box.
+// Output: the correction to the force.
+func EwaldCorrection(d OrderedPair, width float64) OrderedPair {
+	ewald_once.Do(BuildEwaldTable)
+
+	// the correction is odd in each component of the displacement
+	i, j, corners := EwaldCell(d, width)
+	var c OrderedPair
+	for _, corner := range corners {
+		entry := ewald_table[i+int(corner[0])][j+int(corner[1])]
+		c.x += corner[2] * entry.x
+		c.y += corner[2] * entry.y
+	}
+
+	if d.x < 0 {
+		c.x = -c.x
+	}
+	if d.y < 0 {
+		c.y = -c.y
+	}
+	c.x /= width * width
+	c.y /= width * width
+
+	return c
+}
+
+// EwaldPotentialCorrection interpolates the counterpart of 1/d for all images of a unit mass except the nearest one,
+// at a displacement d from that nearest image. The potential of an infinite tiling only converges relative to
+// a reference, so every image at n contributes 1/|d + n| - 1/|n|, which gives the same forces as EwaldCorrection
+// and shifts the total energy by a constant.
+// Input: a nearest image displacement and the width of the box.
+// Output: the correction to the potential factor.
+func EwaldPotentialCorrection(d OrderedPair, width float64) float64 {
+	ewald_once.Do(BuildEwaldTable)
+
+	// the correction is even in each component of the displacement
+	i, j, corners := EwaldCell(d, width)
+	var c float64
+	for _, corner := range corners {
+		c += corner[2] * ewald_potential_table[i+int(corner[0])][j+int(corner[1])]
+	}
+
+	return c / width
+}
+
+// BuildEwaldTable fills the correction tables of a unit box. Each entry sums the images in square rings
+// around the box, which converges like 1/rings, so the sums over n and 2n rings are extrapolated to infinity.
+func BuildEwaldTable() {
+	for i := 0; i <= ewald_table_size; i++ {
+		for j := 0; j <= ewald_table_size; j++ {
+			d := OrderedPair{float64(i) / (2 * ewald_table_size), float64(j) / (2 * ewald_table_size)}
+			near := ImageSum(d, ewald_images)
+			far := ImageSum(d, 2*ewald_images)
+			ewald_table[i][j] = OrderedPair{2*far.x - near.x, 2*far.y - near.y}
+			ewald_potential_table[i][j] = 2*ImagePotentialSum(d, 2*ewald_images) - ImagePotentialSum(d, ewald_images)
+		}
+	}
+}
+
+// ImageSum adds up the force of the images of a unit mass in a unit box, in all rings up to the given one
+// except the nearest image itself, on a unit mass at displacement d from the nearest image.
+func ImageSum(d OrderedPair, rings int) OrderedPair {
+	var sum OrderedPair
+	for i := -rings; i <= rings; i++ {
+		for j := -rings; j <= rings; j++ {
+			if i == 0 && j == 0 {
+				continue
+			}
+			x := d.x + float64(i)
+			y := d.y + float64(j)
+			r := math.Hypot(x, y)
+			sum.x += x / (r * r * r)
+			sum.y += y / (r * r * r)
+		}
+	}
+
+	return sum
+}
+
+// ImagePotentialSum is the counterpart of ImageSum for the potential: it adds up 1/|d + n| - 1/|n|
+// over the images n in all rings up to the given one except the nearest image itself.
+func ImagePotentialSum(d OrderedPair, rings int) float64 {
+	var sum float64
+	for i := -rings; i <= rings; i++ {
+		for j := -rings; j <= rings; j++ {
+			if i == 0 && j == 0 {
+				continue
+			}
+			sum += 1/math.Hypot(d.x+float64(i), d.y+float64(j)) - 1/math.Hypot(float64(i), float64(j))
+		}
+	}
+
+	return sum
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// default_progress_interval is how often a run reports its progress unless asked otherwise.
+const default_progress_interval = 10 * time.Second
+
+// ProgressReporter creates a function that reports how far a run from generation first to generation num_gens has got,
+// at most once every interval of wall-clock time, and once more when the last generation is done.
+// A resumed run starts from the generation of its snapshot, and a new run from zero.
+// The reporter only needs the generation reached, so the observers of both the 2D and the 3D engine can call it.
+// Input: where to write the reports, the first and last generations of the run and the interval between reports.
+// Output: the reporter, to be called with every generation. Its clock starts when it is created.
+func ProgressReporter(w io.Writer, first, num_gens int, interval time.Duration) func(generation int) {
+	start := time.Now()
+	last := start
+
+	return func(generation int) {
+		now := time.Now()
+		if generation == first || (now.Sub(last) < interval && generation != num_gens) {
+			return
+		}
+		last = now
+		fmt.Fprintln(w, FormatProgress(first, generation, num_gens, now.Sub(start)))
+	}
+}
+
+// FormatProgress describes the progress of a run: the generation reached, the elapsed time,
+// the estimated time left at the average speed so far, and that speed.
+// Input: the generation the run started from, the generation reached, the last generation of the run
+// and the time taken to get from the first generation to the one reached.
+// Output: a line of text.
+func FormatProgress(first, generation, num_gens int, elapsed time.Duration) string {
+	rate := float64(generation-first) / elapsed.Seconds()
+	eta := time.Duration(float64(num_gens-generation) / rate * float64(time.Second))
+
+	return fmt.Sprintf("generation %d/%d (%.1f%%), elapsed %s, ETA %s, %.1f steps/s",
+		generation, num_gens, 100*float64(generation)/float64(num_gens),
+		elapsed.Round(time.Second), eta.Round(time.Second), rate)
+}
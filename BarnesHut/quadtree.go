@@ -1,5 +1,12 @@
 package main
 
+import "math"
+
+// max_quadtree_depth is the deepest level of a quadtree below its root, matching the depth of the FlatTree.
+// Stars that still share a sector at this depth (such as stars at the same position) are kept together in a bucket:
+// an internal node whose children are leaves with one star each, all covering the sector of the bucket.
+const max_quadtree_depth = morton_levels
+
 // ConstructQuadTree creates a new quadtree based on a series of stars.
 // Input: a slice of stars and the width of the Universe.
 // Output: a quadtree.
@@ -10,32 +17,116 @@ func ConstructQuadTree(stars []*Star, width float64) *QuadTree {
 	return qt
 }
 
+// BuildQuadTree creates a new quadtree from the stars of a Universe, updates its dummy stars,
+// and carries over the softening of the Universe. Quadrupole moments are only accumulated if the Universe asks for them.
+// The root of the tree is the smallest square around the stars, so stars that left the universe are still sorted properly.
+// Input: a Universe object.
+// Output: a quadtree ready for the tree walk.
+func (u *Universe) BuildQuadTree() *QuadTree {
+	qt := ConstructQuadTreeInSector(u.stars, u.BoundingSector())
+	UpdateDummyStar(qt.root)
+	qt.softening = u.softening
+	if u.quadrupole {
+		UpdateQuadrupole(qt.root)
+		qt.quadrupole = true
+	}
+	if u.Periodic() {
+		qt.period = u.width
+	}
+
+	return qt
+}
+
+// ConstructQuadTreeInSector creates a new quadtree whose root covers the given sector.
+// Input: a slice of stars and a sector containing all of them.
+// Output: a quadtree.
+func ConstructQuadTreeInSector(stars []*Star, sector Quadrant) *QuadTree {
+	qt := InitializeQuadTreeInSector(sector)
+	qt.Insert(stars)
+
+	return qt
+}
+
 // InitializeQuadTree initializes a quadtree that contains a root and four empty children.
 // Input: the width of the Universe
 // Output: a quadtree.
 func InitializeQuadTree(w float64) *QuadTree {
+	return InitializeQuadTreeInSector(Quadrant{x: 0, y: w, width: w})
+}
+
+// InitializeQuadTreeInSector initializes a quadtree whose root covers the given sector and has four empty children.
+// Input: the sector of the root.
+// Output: a quadtree.
+func InitializeQuadTreeInSector(sector Quadrant) *QuadTree {
 	var t QuadTree
 	var r Node
 	var s Star
 	r.star = &s
-	r.sector = Quadrant{x: 0, y: w, width: w}
+	r.sector = sector
 	t.root = &r
 	t.root.Split()
 
 	return &t
 }
 
+// BoundingSector computes the smallest square containing every star of the universe.
+// The square is padded slightly so that the stars on its far edges are strictly inside.
+// Input: a Universe object.
+// Output: the sector to use as the root of a quadtree.
+func (u *Universe) BoundingSector() Quadrant {
+	if len(u.stars) == 0 {
+		return Quadrant{x: 0, y: u.width, width: u.width}
+	}
+
+	min_x, max_x := u.stars[0].position.x, u.stars[0].position.x
+	min_y, max_y := u.stars[0].position.y, u.stars[0].position.y
+	for _, s := range u.stars {
+		min_x = math.Min(min_x, s.position.x)
+		max_x = math.Max(max_x, s.position.x)
+		min_y = math.Min(min_y, s.position.y)
+		max_y = math.Max(max_y, s.position.y)
+	}
+
+	width := math.Max(max_x-min_x, max_y-min_y)
+	if width == 0 {
+		// a single star (or stars all at one point) still needs a sector of some size
+		width = u.width
+	}
+	// the padding must also exceed the rounding error of the coordinates themselves
+	pad := 1e-6 * math.Max(width, math.Max(math.Max(math.Abs(min_x), math.Abs(max_x)), math.Max(math.Abs(min_y), math.Abs(max_y))))
+	width += 2 * pad
+
+	return Quadrant{x: min_x - pad, y: min_y - pad + width, width: width}
+}
+
 // Insert inserts stars one by one to the quadtree based on quadrant.
 // Input: a initialized quadtree and a slice of stars
 // Output: None.
 func (qt *QuadTree) Insert(stars []*Star) {
 	for i := range stars {
+		if !qt.root.InField(stars[i]) {
+			panic("Error: star outside of the root sector in Insert.")
+		}
 		cur := qt.root
 		// determine which quadrant the current star is located in.
 		quadrant := InWhich(stars[i].position, *cur)
 		next := cur.children[quadrant]
+		depth := 1
+		bucketed := false
 
 		for next.star != nil {
+			if depth == max_quadtree_depth {
+				// the sector cannot be split any further --> keep the stars side by side in a bucket
+				if next.children == nil {
+					var bucket *Node = next.CopyNode()
+					bucket.children = []*Node{{star: next.star, sector: next.sector}}
+					cur.children[quadrant] = bucket
+					next = bucket
+				}
+				next.children = append(next.children, &Node{star: stars[i], sector: next.sector})
+				bucketed = true
+				break
+			}
 			// the position that is going to be inserted already has another star
 			if next.children == nil {
 				// the position is already the leaf node --> needs to create new children
@@ -54,8 +145,11 @@ func (qt *QuadTree) Insert(stars []*Star) {
 			}
 			quadrant = InWhich(stars[i].position, *cur)
 			next = cur.children[quadrant]
+			depth++
+		}
+		if !bucketed {
+			next.star = stars[i]
 		}
-		next.star = stars[i]
 	}
 }
 
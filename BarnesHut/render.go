@@ -0,0 +1,197 @@
+package main
+
+import (
+	"canvas"
+	"math"
+)
+
+// RenderStyle chooses how the stars of a Universe are drawn. The modes are "stars" (or empty), which draws every star
+// as a disk of its own color, "density", which draws a log-scaled heatmap of the surface density, and "velocity",
+// which colors every star by how it moves relative to the center of mass. With tree_overlay, the sectors of the
+// quadtree of the universe are outlined on top.
+// The heatmap has cells of density_cell pixels, and spans density_range decades below the densest cell.
+// Zero values stand for cells of 4 pixels and a range of 4 decades.
+type RenderStyle struct {
+	mode          string
+	colormap      string
+	density_cell  int
+	density_range float64
+	tree_overlay  bool
+}
+
+// colormaps holds the colors of the known colormaps at evenly spaced stops from low to high values.
+var colormaps = map[string][][3]float64{
+	"heat":    {{0, 0, 0}, {128, 0, 0}, {255, 64, 0}, {255, 200, 0}, {255, 255, 255}},
+	"viridis": {{68, 1, 84}, {59, 82, 139}, {33, 145, 140}, {94, 201, 98}, {253, 231, 37}},
+	"gray":    {{0, 0, 0}, {255, 255, 255}},
+}
+
+// ValidRenderMode checks whether a render mode is known.
+func ValidRenderMode(mode string) bool {
+	switch mode {
+	case "", "stars", "density", "velocity":
+		return true
+	}
+
+	return false
+}
+
+// ValidColormap checks whether a colormap is known. An empty name stands for "heat".
+func ValidColormap(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, found := colormaps[name]
+
+	return found
+}
+
+// MapColor looks up a value between 0 and 1 in a colormap, interpolating linearly between its stops.
+// Values outside of [0, 1] are clamped.
+func MapColor(name string, t float64) (uint8, uint8, uint8) {
+	if name == "" {
+		name = "heat"
+	}
+	stops, found := colormaps[name]
+	if !found {
+		panic("Error: unknown colormap " + name + ".")
+	}
+
+	t = math.Max(0, math.Min(1, t)) * float64(len(stops)-1)
+	i := int(math.Min(t, float64(len(stops)-2)))
+	f := t - float64(i)
+	var rgb [3]uint8
+	for k := range rgb {
+		rgb[k] = uint8(math.Round(stops[i][k] + f*(stops[i+1][k]-stops[i][k])))
+	}
+
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// SurfaceDensity bins the mass of the stars into square cells covering a canvas that shows a viewport.
+// Input: a Universe, a viewport, the width of the canvas and of a cell, in pixels.
+// Output: the mass per unit area of every cell, indexed [column][row]. Stars outside the viewport are left out.
+func (u *Universe) SurfaceDensity(view Viewport, canvas_width, cell int) [][]float64 {
+	num_cells := (canvas_width + cell - 1) / cell
+	density := make([][]float64, num_cells)
+	for i := range density {
+		density[i] = make([]float64, num_cells)
+	}
+
+	area := view.width * view.width * float64(cell*cell) / float64(canvas_width*canvas_width)
+	for _, s := range u.stars {
+		cx, cy := view.ToCanvas(s.position, canvas_width)
+		if cx < 0 || cy < 0 {
+			continue
+		}
+		i, j := int(cx)/cell, int(cy)/cell
+		if i < num_cells && j < num_cells {
+			density[i][j] += s.mass / area
+		}
+	}
+
+	return density
+}
+
+// DrawDensity draws the surface density of a universe as a heatmap, mapping the logarithm of the density of every cell
+// to the colormap of the style, from density_range decades below the densest cell (and empty cells) to the densest cell.
+func (u *Universe) DrawDensity(c *canvas.Canvas, view Viewport, canvas_width int, style RenderStyle) {
+	cell := style.density_cell
+	if cell <= 0 {
+		cell = 4
+	}
+	decades := style.density_range
+	if decades <= 0 {
+		decades = 4
+	}
+
+	density := u.SurfaceDensity(view, canvas_width, cell)
+	max_density := 0.0
+	for i := range density {
+		for j := range density[i] {
+			max_density = math.Max(max_density, density[i][j])
+		}
+	}
+	if max_density == 0 {
+		return
+	}
+
+	for i := range density {
+		for j := range density[i] {
+			if density[i][j] == 0 {
+				continue
+			}
+			t := 1 + math.Log10(density[i][j]/max_density)/decades
+			r, g, b := MapColor(style.colormap, t)
+			c.SetFillColor(canvas.MakeColor(r, g, b))
+			c.ClearRect(i*cell, j*cell, (i+1)*cell, (j+1)*cell)
+			c.Fill()
+		}
+	}
+}
+
+// VelocityColor colors a star by its velocity relative to a center of mass moving at com_velocity:
+// red for moving outwards, green for moving inwards and blue for moving around the center.
+// The brightness grows with the speed, up to max_speed, and never drops below a third so that slow stars stay visible.
+func (s *Star) VelocityColor(com, com_velocity OrderedPair, max_speed float64) (uint8, uint8, uint8) {
+	vx, vy := s.velocity.x-com_velocity.x, s.velocity.y-com_velocity.y
+	speed := math.Hypot(vx, vy)
+	rx, ry := s.position.x-com.x, s.position.y-com.y
+	r := math.Hypot(rx, ry)
+	if speed == 0 || r == 0 || max_speed == 0 {
+		return 85, 85, 85
+	}
+
+	radial := (rx*vx + ry*vy) / (r * speed)
+	tangential := math.Abs(rx*vy-ry*vx) / (r * speed)
+	brightness := 255 * (1 + 2*math.Min(1, speed/max_speed)) / 3
+
+	return uint8(brightness * math.Max(radial, 0)), uint8(brightness * math.Max(-radial, 0)), uint8(brightness * tangential)
+}
+
+// DrawVelocityField draws every star as a disk colored by VelocityColor, relative to the center of mass of the universe.
+func (u *Universe) DrawVelocityField(c *canvas.Canvas, view Viewport, canvas_width int, scaling_factor float64) {
+	com, com_velocity, _ := GalaxyCenterOfMass(u.stars)
+	max_speed := 0.0
+	for _, s := range u.stars {
+		max_speed = math.Max(max_speed, math.Hypot(s.velocity.x-com_velocity.x, s.velocity.y-com_velocity.y))
+	}
+
+	for _, s := range u.stars {
+		c.SetFillColor(canvas.MakeColor(s.VelocityColor(com, com_velocity, max_speed)))
+		cx, cy := view.ToCanvas(s.position, canvas_width)
+		c.Circle(cx, cy, scaling_factor*view.Scale(s.radius, canvas_width))
+		c.Fill()
+	}
+}
+
+// DrawTreeOverlay outlines the sector of every non-empty node of the quadtree of a universe.
+// It is built on the same bounding sector as the tree used for the forces, so it shows how that tree divides the stars.
+func (u *Universe) DrawTreeOverlay(c *canvas.Canvas, view Viewport, canvas_width int) {
+	if len(u.stars) == 0 {
+		return
+	}
+	qt := u.BuildQuadTree()
+
+	c.SetLineWidth(1)
+	c.SetStrokeColor(canvas.MakeColor(0, 110, 0))
+	stack := []*Node{qt.root}
+	for len(stack) != 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur.star == nil {
+			continue
+		}
+
+		left, top := view.ToCanvas(OrderedPair{cur.sector.x, cur.sector.y - cur.sector.width}, canvas_width)
+		right, bottom := view.ToCanvas(OrderedPair{cur.sector.x + cur.sector.width, cur.sector.y}, canvas_width)
+		c.MoveTo(left, top)
+		c.LineTo(right, top)
+		c.LineTo(right, bottom)
+		c.LineTo(left, bottom)
+		c.LineTo(left, top)
+		c.Stroke()
+
+		stack = append(stack, cur.children...)
+	}
+}
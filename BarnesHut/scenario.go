@@ -0,0 +1,611 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Scenario is a declarative description of a simulation, read from a JSON file.
+// It is simulated in the plane unless its dimensions are 3, in which case its galaxies are disks in space.
+// The fields are exported only so that encoding/json can see them.
+type Scenario struct {
+	Name            string             `json:"name"`
+	Seed            int64              `json:"seed"`
+	Dimensions      int                `json:"dimensions"`
+	Width           float64            `json:"width"`
+	Softening       SofteningScenario  `json:"softening"`
+	EscapePolicy    string             `json:"escape_policy"`
+	Quadrupole      bool               `json:"quadrupole"`
+	CollisionPolicy string             `json:"collision_policy"`
+	Bodies          []BodyScenario     `json:"bodies"`
+	Galaxies        []GalaxyScenario   `json:"galaxies"`
+	Encounter       *EncounterScenario `json:"encounter"`
+	Tracking        *TrackingOptions   `json:"tracking"`
+	Orbits          *OrbitOptions      `json:"orbits"`
+	Integration     IntegrationOptions `json:"integration"`
+	Rendering       RenderingOptions   `json:"rendering"`
+}
+
+// SofteningScenario describes the Softening of a scenario.
+type SofteningScenario struct {
+	Kernel string  `json:"kernel"`
+	Length float64 `json:"length"`
+}
+
+// BodyScenario describes a single star placed by hand, such as a planet or a moon.
+// The known period (in seconds, zero if unknown) is compared with the measured one by the orbit analysis.
+type BodyScenario struct {
+	Name        string     `json:"name"`
+	Position    [2]float64 `json:"position"`
+	Velocity    [2]float64 `json:"velocity"`
+	Mass        float64    `json:"mass"`
+	Radius      float64    `json:"radius"`
+	Color       [3]uint8   `json:"color"`
+	KnownPeriod float64    `json:"known_period"`
+}
+
+// GalaxyScenario describes a generated galaxy, which is then pushed with a velocity.
+// The profile chooses the generator: "annulus" (or empty) for InitializeGalaxy, "exponential" for ExponentialDisk,
+// and "plummer" or "hernquist" for PlummerSphere or HernquistSphere. Radius is the outer edge of every profile,
+// while the scale length, the mass function and the central mass are only used by the newer generators.
+// The scale height is the thickness of the disk of a galaxy in a scenario with three dimensions.
+type GalaxyScenario struct {
+	NumStars     int        `json:"num_stars"`
+	Radius       float64    `json:"radius"`
+	Center       [2]float64 `json:"center"`
+	Push         [2]float64 `json:"push"`
+	Profile      string     `json:"profile"`
+	ScaleLength  float64    `json:"scale_length"`
+	MassFunction string     `json:"mass_function"`
+	CentralMass  float64    `json:"central_mass"`
+	ScaleHeight  float64    `json:"scale_height"`
+}
+
+// EncounterScenario places the two galaxies of a scenario on a Keplerian orbit with SetupEncounter,
+// instead of using their centers and pushes.
+type EncounterScenario struct {
+	Pericenter   float64    `json:"pericenter"`
+	Eccentricity float64    `json:"eccentricity"`
+	Separation   float64    `json:"separation"`
+	Inclinations [2]float64 `json:"inclinations"`
+	Center       [2]float64 `json:"center"`
+}
+
+// TrackingOptions chooses stars whose paths are recorded to a CSV file and drawn as trails.
+// Stars are given by id: the bodies of a scenario are numbered from 1 in order, followed by the stars of each galaxy,
+// whose central black hole comes last.
+type TrackingOptions struct {
+	IDs         []int `json:"ids"`
+	Frequency   int   `json:"frequency"`
+	TrailLength int   `json:"trail_length"`
+}
+
+// OrbitOptions asks for the orbits of every other body around the body named primary to be analyzed
+// every frequency generations.
+type OrbitOptions struct {
+	Primary   string `json:"primary"`
+	Frequency int    `json:"frequency"`
+}
+
+// IntegrationOptions holds the parameters passed to StreamBarnesHut.
+type IntegrationOptions struct {
+	NumGens    int     `json:"num_gens"`
+	Time       float64 `json:"time"`
+	Theta      float64 `json:"theta"`
+	Integrator string  `json:"integrator"`
+	NumProcs   int     `json:"num_procs"`
+}
+
+// RenderingOptions holds the parameters used to draw the frames of the animation.
+// The mode, colormap, density cell and range, and tree overlay are those of a RenderStyle.
+// The output is one of the formats of ValidOutputFormat, played at frame_rate frames per second;
+// the "pipe" output sends the frames to the encoder given by command.
+// A scenario with three dimensions is viewed from the azimuth and elevation of a Camera, in degrees.
+type RenderingOptions struct {
+	CanvasWidth      int            `json:"canvas_width"`
+	DrawingFrequency int            `json:"drawing_frequency"`
+	ScalingFactor    float64        `json:"scaling_factor"`
+	Camera           *CameraOptions `json:"camera"`
+	Mode             string         `json:"mode"`
+	Colormap         string         `json:"colormap"`
+	DensityCell      int            `json:"density_cell"`
+	DensityRange     float64        `json:"density_range"`
+	TreeOverlay      bool           `json:"tree_overlay"`
+	Output           string         `json:"output"`
+	FrameRate        int            `json:"frame_rate"`
+	Command          []string       `json:"command"`
+	Azimuth          float64        `json:"azimuth"`
+	Elevation        float64        `json:"elevation"`
+}
+
+// CameraOptions describe the camera of the animation; without them every frame shows the whole universe.
+// The mode is one of those of Camera2D. The followed star is given by the name of a body or by its id.
+// A zero width stands for the width of the universe, and a fixed camera with zero width shows the whole universe.
+type CameraOptions struct {
+	Mode      string     `json:"mode"`
+	Center    [2]float64 `json:"center"`
+	Width     float64    `json:"width"`
+	Star      string     `json:"star"`
+	ID        int        `json:"id"`
+	Margin    float64    `json:"margin"`
+	Smoothing float64    `json:"smoothing"`
+}
+
+// LoadScenario reads and validates a scenario file.
+// Unknown fields are rejected so that a misspelled parameter is not silently ignored.
+// Input: a file name.
+// Output: the Scenario, and an error describing every problem found in the file.
+func LoadScenario(filename string) (*Scenario, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sc Scenario
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := sc.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return &sc, nil
+}
+
+// Validate checks every field of a scenario.
+// Output: nil if the scenario can be run, otherwise an error listing all the problems.
+func (sc *Scenario) Validate() error {
+	problems := make([]error, 0)
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if sc.Name == "" {
+		add("name must not be empty")
+	}
+	if sc.Dimensions != 0 && sc.Dimensions != 2 && sc.Dimensions != 3 {
+		add("dimensions must be 2 or 3, got %d", sc.Dimensions)
+	}
+	if sc.Width <= 0 {
+		add("width must be positive, got %g", sc.Width)
+	}
+	if !(Softening{sc.Softening.Kernel, sc.Softening.Length}).ValidSoftening() {
+		add("softening kernel %q with length %g is not valid", sc.Softening.Kernel, sc.Softening.Length)
+	}
+	if !ValidEscapePolicy(sc.EscapePolicy) {
+		add("unknown escape_policy %q", sc.EscapePolicy)
+	}
+	if !ValidCollisionPolicy(sc.CollisionPolicy) {
+		add("unknown collision_policy %q", sc.CollisionPolicy)
+	}
+	if len(sc.Bodies) == 0 && len(sc.Galaxies) == 0 {
+		add("at least one body or galaxy is required")
+	}
+	for i, b := range sc.Bodies {
+		if b.Mass <= 0 {
+			add("bodies[%d] (%s): mass must be positive, got %g", i, b.Name, b.Mass)
+		}
+		if b.Radius < 0 {
+			add("bodies[%d] (%s): radius must not be negative, got %g", i, b.Name, b.Radius)
+		}
+		if b.KnownPeriod < 0 {
+			add("bodies[%d] (%s): known_period must not be negative, got %g", i, b.Name, b.KnownPeriod)
+		}
+	}
+	for i, g := range sc.Galaxies {
+		if g.NumStars <= 0 {
+			add("galaxies[%d]: num_stars must be positive, got %d", i, g.NumStars)
+		}
+		if g.Radius <= 0 {
+			add("galaxies[%d]: radius must be positive, got %g", i, g.Radius)
+		}
+		switch g.Profile {
+		case "", "annulus":
+		case "exponential", "plummer", "hernquist":
+			if g.ScaleLength <= 0 || g.ScaleLength >= g.Radius {
+				add("galaxies[%d]: scale_length must be between 0 and radius, got %g", i, g.ScaleLength)
+			}
+		default:
+			add("galaxies[%d]: unknown profile %q", i, g.Profile)
+		}
+		if !ValidMassFunction(g.MassFunction) {
+			add("galaxies[%d]: unknown mass_function %q", i, g.MassFunction)
+		}
+		if g.CentralMass < 0 {
+			add("galaxies[%d]: central_mass must not be negative, got %g", i, g.CentralMass)
+		}
+	}
+	if e := sc.Encounter; e != nil {
+		if len(sc.Galaxies) != 2 {
+			add("encounter needs exactly two galaxies, got %d", len(sc.Galaxies))
+		}
+		if e.Pericenter <= 0 {
+			add("encounter.pericenter must be positive, got %g", e.Pericenter)
+		}
+		if e.Eccentricity < 1 {
+			add("encounter.eccentricity must be at least 1, got %g", e.Eccentricity)
+		}
+		if e.Separation < e.Pericenter {
+			add("encounter.separation must be at least the pericenter, got %g", e.Separation)
+		}
+		// a disk in space may be tilted by any angle, but in the plane it can only be flipped
+		for k, inclination := range e.Inclinations {
+			if sc.Dimensions != 3 && inclination != 0 && inclination != 180 {
+				add("encounter.inclinations[%d] must be 0 or 180, got %g", k, inclination)
+			}
+		}
+	}
+	if tr := sc.Tracking; tr != nil {
+		if len(tr.IDs) == 0 {
+			add("tracking.ids must not be empty")
+		}
+		if tr.Frequency <= 0 {
+			add("tracking.frequency must be positive, got %d", tr.Frequency)
+		}
+		if tr.TrailLength < 0 {
+			add("tracking.trail_length must not be negative, got %d", tr.TrailLength)
+		}
+	}
+	if o := sc.Orbits; o != nil {
+		if sc.BodyID(o.Primary) == 0 {
+			add("orbits.primary %q is not the name of a body", o.Primary)
+		}
+		if o.Frequency <= 0 {
+			add("orbits.frequency must be positive, got %d", o.Frequency)
+		}
+	}
+	if sc.Dimensions == 3 {
+		if err := sc.Validate3D(); err != nil {
+			problems = append(problems, err)
+		}
+	} else if sc.Rendering.Azimuth != 0 || sc.Rendering.Elevation != 0 {
+		add("rendering.azimuth and rendering.elevation are only supported in three dimensions")
+	}
+	if err := sc.ValidateSettings(); err != nil {
+		problems = append(problems, err)
+	}
+
+	return errors.Join(problems...)
+}
+
+// ValidateSettings checks the integration and rendering options of a scenario, which are all that a run resumed
+// from a snapshot needs, since its stars come from the snapshot.
+// Output: an error describing every problem found, or nil.
+func (sc *Scenario) ValidateSettings() error {
+	problems := make([]error, 0)
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if sc.Integration.NumGens < 0 {
+		add("integration.num_gens must not be negative, got %d", sc.Integration.NumGens)
+	}
+	if sc.Integration.Time <= 0 {
+		add("integration.time must be positive, got %g", sc.Integration.Time)
+	}
+	if sc.Integration.Theta < 0 {
+		add("integration.theta must not be negative, got %g", sc.Integration.Theta)
+	}
+	if !ValidIntegrator(sc.Integration.Integrator) {
+		add("unknown integration.integrator %q", sc.Integration.Integrator)
+	}
+	if sc.Rendering.CanvasWidth <= 0 {
+		add("rendering.canvas_width must be positive, got %d", sc.Rendering.CanvasWidth)
+	}
+	if sc.Rendering.DrawingFrequency <= 0 {
+		add("rendering.drawing_frequency must be positive, got %d", sc.Rendering.DrawingFrequency)
+	}
+	if sc.Rendering.ScalingFactor <= 0 {
+		add("rendering.scaling_factor must be positive, got %g", sc.Rendering.ScalingFactor)
+	}
+	if !ValidRenderMode(sc.Rendering.Mode) {
+		add("unknown rendering.mode %q", sc.Rendering.Mode)
+	}
+	if !ValidColormap(sc.Rendering.Colormap) {
+		add("unknown rendering.colormap %q", sc.Rendering.Colormap)
+	}
+	if sc.Rendering.DensityCell < 0 {
+		add("rendering.density_cell must not be negative, got %d", sc.Rendering.DensityCell)
+	}
+	if sc.Rendering.DensityRange < 0 {
+		add("rendering.density_range must not be negative, got %g", sc.Rendering.DensityRange)
+	}
+	if !ValidOutputFormat(sc.Rendering.Output) {
+		add("unknown rendering.output %q", sc.Rendering.Output)
+	}
+	if sc.Rendering.FrameRate < 0 || sc.Rendering.FrameRate > math.MaxUint16 {
+		add("rendering.frame_rate must be between 0 and %d, got %d", math.MaxUint16, sc.Rendering.FrameRate)
+	}
+	if sc.Rendering.Output == "pipe" && len(sc.Rendering.Command) == 0 {
+		add("rendering.command must give the encoder of the pipe output")
+	}
+	if sc.Rendering.Elevation < -90 || sc.Rendering.Elevation > 90 {
+		add("rendering.elevation must be between -90 and 90, got %g", sc.Rendering.Elevation)
+	}
+	if cam := sc.Rendering.Camera; cam != nil {
+		if !ValidCameraMode(cam.Mode) {
+			add("unknown rendering.camera.mode %q", cam.Mode)
+		}
+		if cam.Mode == "star" && sc.BodyID(cam.Star) == 0 && cam.ID <= 0 {
+			add("rendering.camera must name a body in star or give a positive id to follow a star")
+		}
+		if cam.Star != "" && sc.BodyID(cam.Star) == 0 {
+			add("rendering.camera.star %q is not the name of a body", cam.Star)
+		}
+		if cam.Width < 0 {
+			add("rendering.camera.width must not be negative, got %g", cam.Width)
+		}
+		if cam.Margin < 0 {
+			add("rendering.camera.margin must not be negative, got %g", cam.Margin)
+		}
+		if cam.Smoothing < 0 || cam.Smoothing >= 1 {
+			add("rendering.camera.smoothing must be in [0, 1), got %g", cam.Smoothing)
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+// BuildUniverse creates the initial Universe of a scenario: its bodies, followed by the stars of its galaxies.
+// Every galaxy is drawn from one source of randomness seeded by the scenario, so a scenario always gives the same universe.
+// Output: a pointer to the resulting universe.
+func (sc *Scenario) BuildUniverse() *Universe {
+	galaxies := make([]Galaxy, 0)
+	rng := rand.New(rand.NewSource(sc.Seed))
+
+	if len(sc.Bodies) > 0 {
+		bodies := make(Galaxy, len(sc.Bodies))
+		for i, b := range sc.Bodies {
+			var s Star
+			s.position = OrderedPair{b.Position[0], b.Position[1]}
+			s.velocity = OrderedPair{b.Velocity[0], b.Velocity[1]}
+			s.mass = b.Mass
+			s.radius = b.Radius
+			s.red, s.green, s.blue = b.Color[0], b.Color[1], b.Color[2]
+			bodies[i] = &s
+		}
+		galaxies = append(galaxies, bodies)
+	}
+
+	first_galaxy := len(galaxies)
+	for _, g := range sc.Galaxies {
+		galaxy := g.Generate(rng)
+		Push(&galaxy, OrderedPair{g.Push[0], g.Push[1]})
+		galaxies = append(galaxies, galaxy)
+	}
+	if e := sc.Encounter; e != nil {
+		SetupEncounter(galaxies[first_galaxy], galaxies[first_galaxy+1], e.Pericenter, e.Eccentricity, e.Separation,
+			e.Inclinations, OrderedPair{e.Center[0], e.Center[1]})
+	}
+
+	u := InitializeUniverse(galaxies, sc.Width)
+	u.softening = Softening{sc.Softening.Kernel, sc.Softening.Length}
+	u.escape_policy = sc.EscapePolicy
+	u.quadrupole = sc.Quadrupole
+	u.collision_policy = sc.CollisionPolicy
+	u.integrator = sc.Integration.Integrator
+
+	return u
+}
+
+// BodyID finds the id that BuildUniverse gives to the body with the given name.
+// Output: the id, or zero if there is no such body.
+func (sc *Scenario) BodyID(name string) int {
+	for i, b := range sc.Bodies {
+		if b.Name == name {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// OutputFormat is the format of the frames of a scenario, "gif" unless it asks for another one.
+func (sc *Scenario) OutputFormat() string {
+	if sc.Rendering.Output == "" {
+		return "gif"
+	}
+
+	return sc.Rendering.Output
+}
+
+// Style collects the options of the render style.
+func (r RenderingOptions) Style() RenderStyle {
+	return RenderStyle{r.Mode, r.Colormap, r.DensityCell, r.DensityRange, r.TreeOverlay}
+}
+
+// BuildCamera creates the camera described by the rendering options of a scenario.
+// Output: the camera, or nil to show the whole universe in every frame.
+func (sc *Scenario) BuildCamera() *Camera2D {
+	cam := sc.Rendering.Camera
+	if cam == nil {
+		return nil
+	}
+	id := cam.ID
+	if cam.Star != "" {
+		id = sc.BodyID(cam.Star)
+	}
+	view := Viewport{OrderedPair{cam.Center[0], cam.Center[1]}, cam.Width}
+
+	return NewCamera2D(cam.Mode, id, view, cam.Margin, cam.Smoothing)
+}
+
+// Generate draws the stars of a galaxy with the generator chosen by its profile.
+// Input: a validated GalaxyScenario and a source of randomness.
+// Output: the Galaxy.
+func (g GalaxyScenario) Generate(rng *rand.Rand) Galaxy {
+	masses := GetMassFunction(g.MassFunction)
+
+	switch g.Profile {
+	case "exponential":
+		return ExponentialDisk(rng, g.NumStars, g.ScaleLength, g.Radius, g.CentralMass, g.Center[0], g.Center[1], masses)
+	case "plummer":
+		return PlummerSphere(rng, g.NumStars, g.ScaleLength, g.Radius, g.Center[0], g.Center[1], masses)
+	case "hernquist":
+		return HernquistSphere(rng, g.NumStars, g.ScaleLength, g.Radius, g.Center[0], g.Center[1], masses)
+	}
+
+	return InitializeGalaxy(rng, g.NumStars, g.Radius, g.Center[0], g.Center[1])
+}
+
+// RunScenario simulates a scenario, drawing frames and computing diagnostics while it runs.
+// It writes the frames to the output of the rendering options (<name>.out.gif by default), <name>.diagnostics.csv,
+// <name>.snap, <name>.escapes.csv for the "remove" escape policy, <name>.mergers.csv for the "merge" collision policy,
+// <name>.trajectories.csv if any stars are tracked, and <name>.orbits.csv and <name>.periods.csv if orbits are analyzed.
+// Progress is printed every progress interval of wall-clock time, or never if it is not positive.
+// If ctx is cancelled, the run stops after the current generation and still writes every file,
+// with the snapshot of the last generation computed and the frames drawn so far.
+// Input: a context, a validated Scenario and a progress interval.
+// Output: an error if any of the output files could not be written, or wrapping the error of the context if it was cancelled.
+func RunScenario(ctx context.Context, sc *Scenario, progress time.Duration) error {
+	if sc.Dimensions == 3 {
+		return RunScenario3D(ctx, sc, progress)
+	}
+
+	return RunScenarioFrom(ctx, sc, sc.BuildUniverse(), 0, progress)
+}
+
+// RunScenarioFrom is RunScenario starting from a given universe, such as one loaded from a snapshot,
+// that has already run for some generations. Only the integration and rendering options of the scenario are used,
+// along with its tracking and orbit analysis.
+// Input: a context, a Scenario with valid settings, the initial Universe, its generation and a progress interval.
+// Output: as for RunScenario.
+func RunScenarioFrom(ctx context.Context, sc *Scenario, initial_universe *Universe, generation int, progress time.Duration) error {
+	integration := sc.Integration
+	rendering := sc.Rendering
+	// the snapshot records the scheme of this run, which may differ from that of a resumed one
+	initial_universe.integrator = integration.Integrator
+
+	// the name of the scenario may put its output files in a directory of their own
+	if err := os.MkdirAll(filepath.Dir(sc.Name), 0755); err != nil {
+		return err
+	}
+
+	// frames and diagnostics are produced while the simulation runs, so only the current Universe is kept
+	sink, err := NewFrameSink(rendering.Output, sc.Name, rendering.FrameRate, rendering.Command)
+	if err != nil {
+		return err
+	}
+	diagnostics := make([]Diagnostics, 0)
+	camera := sc.BuildCamera()
+	style := rendering.Style()
+	drawing := CameraDrawingObserver(sink, rendering.CanvasWidth, rendering.DrawingFrequency, rendering.ScalingFactor, camera, style, nil, 0)
+	var trajectories Trajectories
+	if tr := sc.Tracking; tr != nil {
+		trajectories = NewTrajectories(tr.IDs)
+		drawing = CombineObservers(
+			TrajectoryObserver(trajectories, tr.Frequency),
+			CameraDrawingObserver(sink, rendering.CanvasWidth, rendering.DrawingFrequency, rendering.ScalingFactor, camera, style, trajectories, tr.TrailLength),
+		)
+	}
+	observer := CombineObservers(
+		drawing,
+		DiagnosticsObserver(&diagnostics, integration.Theta, rendering.DrawingFrequency),
+	)
+	var orbits *OrbitAnalysis
+	if o := sc.Orbits; o != nil {
+		primary := sc.BodyID(o.Primary)
+		ids := make([]int, 0)
+		for i := range sc.Bodies {
+			if i+1 != primary {
+				ids = append(ids, i+1)
+			}
+		}
+		orbits = NewOrbitAnalysis(primary, ids)
+		observer = CombineObservers(observer, OrbitObserver(orbits, o.Frequency))
+	}
+	if progress > 0 {
+		report := ProgressReporter(os.Stdout, generation, generation+integration.NumGens, progress)
+		observer = CombineObservers(observer, func(generation int, u *Universe) {
+			report(generation)
+		})
+	}
+	// a resumed run continues the numbering of the generations, and the times, of the original run
+	observer = OffsetObserver(observer, generation)
+
+	fmt.Println("Simulating", sc.Name+".")
+	final_universe, completed, interrupted := StreamBarnesHutContext(ctx, initial_universe, integration.NumGens, integration.Time, integration.Theta, integration.Integrator, integration.NumProcs, observer)
+
+	if interrupted != nil {
+		fmt.Println("Simulation interrupted after", completed, "of", integration.NumGens, "generations. Now writing what was computed.")
+	} else {
+		fmt.Println("Simulation run. Now writing diagnostics and snapshot.")
+	}
+	if err := WriteDiagnostics(diagnostics, sc.Name+".diagnostics.csv"); err != nil {
+		return err
+	}
+	if err := final_universe.SaveSnapshot(sc.Name+".snap", generation+completed); err != nil {
+		return err
+	}
+	if sc.EscapePolicy == "remove" {
+		if err := WriteEscapes(final_universe.escapes, sc.Name+".escapes.csv"); err != nil {
+			return err
+		}
+		fmt.Println(len(final_universe.escapes), "stars escaped the universe.")
+	}
+	if trajectories != nil {
+		if err := WriteTrajectories(trajectories, sc.Name+".trajectories.csv"); err != nil {
+			return err
+		}
+	}
+	if orbits != nil {
+		if err := sc.ReportOrbits(orbits); err != nil {
+			return err
+		}
+	}
+	if sc.CollisionPolicy == "merge" {
+		if err := WriteMergers(final_universe.mergers, sc.Name+".mergers.csv"); err != nil {
+			return err
+		}
+		fmt.Println(len(final_universe.mergers), "mergers happened.")
+	}
+
+	fmt.Println("Images drawn. Now finishing the", sc.OutputFormat(), "output.")
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	fmt.Println("Output written.")
+
+	if interrupted != nil {
+		return fmt.Errorf("interrupted at generation %d, saved in %s: %w", generation+completed, sc.Name+".snap", interrupted)
+	}
+
+	return nil
+}
+
+// ReportOrbits writes the orbital elements recorded during a run, and prints and writes the measured period
+// of every body that orbits the primary next to its known period.
+// Input: a Scenario and the OrbitAnalysis filled while it ran.
+// Output: an error if either file could not be written.
+func (sc *Scenario) ReportOrbits(orbits *OrbitAnalysis) error {
+	if err := WriteOrbits(orbits, sc.Name+".orbits.csv"); err != nil {
+		return err
+	}
+
+	reports := make([]PeriodReport, 0, len(orbits.ids))
+	for _, id := range orbits.ids {
+		b := sc.Bodies[id-1]
+		reports = append(reports, PeriodReport{b.Name, id, orbits.MeasuredPeriod(id), b.KnownPeriod})
+	}
+
+	fmt.Println("Orbital periods around", sc.Orbits.Primary+":")
+	for _, r := range reports {
+		if r.known > 0 {
+			fmt.Printf("  %-10s measured %12.1f s, known %12.1f s, error %.3f%%\n", r.name, r.measured, r.known, 100*r.RelativeError())
+		} else {
+			fmt.Printf("  %-10s measured %12.1f s\n", r.name, r.measured)
+		}
+	}
+
+	return WritePeriodReport(reports, sc.Name+".periods.csv")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Validate3D checks the parts of a scenario with three dimensions that differ from those of a plane.
+// The three dimensional engine only evolves generated disks under softened gravity, so the settings
+// that it has no counterpart for are rejected rather than silently ignored.
+// Output: an error listing all the problems, or nil.
+func (sc *Scenario) Validate3D() error {
+	problems := make([]error, 0)
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if len(sc.Bodies) != 0 {
+		add("bodies are only supported in two dimensions")
+	}
+	for i, g := range sc.Galaxies {
+		if g.Profile != "" && g.Profile != "annulus" {
+			add("galaxies[%d]: only the annulus profile is supported in three dimensions, got %q", i, g.Profile)
+		}
+		if g.ScaleHeight < 0 {
+			add("galaxies[%d]: scale_height must not be negative, got %g", i, g.ScaleHeight)
+		}
+	}
+	if sc.EscapePolicy != "" && sc.EscapePolicy != "keep" {
+		add("escape_policy %q is only supported in two dimensions", sc.EscapePolicy)
+	}
+	if sc.CollisionPolicy != "" && sc.CollisionPolicy != "ignore" {
+		add("collision_policy %q is only supported in two dimensions", sc.CollisionPolicy)
+	}
+	if sc.Quadrupole {
+		add("quadrupole is only supported in two dimensions")
+	}
+	if sc.Tracking != nil {
+		add("tracking is only supported in two dimensions")
+	}
+	if sc.Orbits != nil {
+		add("orbits are only supported in two dimensions")
+	}
+	if sc.Rendering.Camera != nil {
+		add("rendering.camera is only supported in two dimensions")
+	}
+	if sc.Rendering.Mode != "" && sc.Rendering.Mode != "stars" {
+		add("rendering.mode %q is only supported in two dimensions", sc.Rendering.Mode)
+	}
+	if sc.Rendering.TreeOverlay {
+		add("rendering.tree_overlay is only supported in two dimensions")
+	}
+	if ValidIntegrator(sc.Integration.Integrator) && !ValidIntegrator3D(sc.Integration.Integrator) {
+		add("integration.integrator %q is only supported in two dimensions", sc.Integration.Integrator)
+	}
+
+	return errors.Join(problems...)
+}
+
+// BuildUniverse3D creates the initial Universe3D of a scenario with three dimensions: the disks of its galaxies,
+// centered in the z = 0 plane and pushed, or placed on the encounter of the scenario.
+// Every galaxy is drawn from one source of randomness seeded by the scenario, so a scenario always gives the same universe.
+// Output: a pointer to the resulting universe.
+func (sc *Scenario) BuildUniverse3D() *Universe3D {
+	galaxies := make([]Galaxy3D, 0)
+	rng := rand.New(rand.NewSource(sc.Seed))
+
+	for _, g := range sc.Galaxies {
+		galaxy := InitializeGalaxy3D(rng, g.NumStars, g.Radius, g.ScaleHeight, g.Center[0], g.Center[1], 0)
+		Push3D(&galaxy, Triple{g.Push[0], g.Push[1], 0})
+		galaxies = append(galaxies, galaxy)
+	}
+	if e := sc.Encounter; e != nil {
+		SetupEncounter3D(galaxies[0], galaxies[1], e.Pericenter, e.Eccentricity, e.Separation,
+			e.Inclinations, Triple{e.Center[0], e.Center[1], 0})
+	}
+
+	u := InitializeUniverse3D(galaxies, sc.Width)
+	u.softening = Softening{sc.Softening.Kernel, sc.Softening.Length}
+
+	return u
+}
+
+// RunScenario3D simulates a scenario with three dimensions, drawing frames while it runs
+// and writing them to the output of the rendering options (<name>.out.gif by default).
+// If ctx is cancelled, the frames drawn so far are still written.
+// Input: a context, a validated Scenario and a progress interval.
+// Output: an error if the output could not be written, or wrapping the error of the context if it was cancelled.
+func RunScenario3D(ctx context.Context, sc *Scenario, progress time.Duration) error {
+	integration, rendering := sc.Integration, sc.Rendering
+	initial_universe := sc.BuildUniverse3D()
+	camera := Camera{azimuth: rendering.Azimuth, elevation: rendering.Elevation}
+
+	image_list := make([]image.Image, 0)
+	observer := DrawingObserver3D(&image_list, rendering.CanvasWidth, rendering.DrawingFrequency, rendering.ScalingFactor, camera)
+	if progress > 0 {
+		report := ProgressReporter(os.Stdout, 0, integration.NumGens, progress)
+		drawing := observer
+		observer = func(generation int, u *Universe3D) {
+			drawing(generation, u)
+			report(generation)
+		}
+	}
+
+	fmt.Println("Simulating", sc.Name+".")
+	completed, interrupted := StreamBarnesHut3DContext(ctx, initial_universe, integration.NumGens, integration.Time, integration.Theta, integration.Integrator, integration.NumProcs, observer)
+
+	if interrupted != nil {
+		fmt.Println("Simulation interrupted after", completed, "of", integration.NumGens, "generations. Now writing the frames drawn so far.")
+	} else {
+		fmt.Println("Simulation run. Now writing the", sc.OutputFormat(), "output.")
+	}
+	if err := os.MkdirAll(filepath.Dir(sc.Name), 0755); err != nil {
+		return err
+	}
+	sink, err := NewFrameSink(rendering.Output, sc.Name, rendering.FrameRate, rendering.Command)
+	if err != nil {
+		return err
+	}
+	for _, img := range image_list {
+		sink.WriteFrame(img)
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	fmt.Println("Output written.")
+
+	if interrupted != nil {
+		return fmt.Errorf("interrupted at generation %d: %w", completed, interrupted)
+	}
+
+	return nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gifhelper"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// default_frame_rate is the number of frames per second of an APNG or a pipe when the run does not ask for one.
+const default_frame_rate = 25
+
+// FrameSink receives the frames of an animation one by one, as they are drawn, and finishes the output on Close.
+// A sink that fails to write a frame keeps the error, drops the later frames and returns the error from Close.
+type FrameSink interface {
+	WriteFrame(img image.Image) error
+	Close() error
+}
+
+// ValidOutputFormat checks whether an output format is known. The formats are "gif" (or empty), which keeps every frame
+// and writes <name>.out.gif at the end, "png", which writes every frame to <name>.frames/frame_00000.png and so on,
+// "apng", which writes the animated PNG <name>.out.png, and "pipe", which streams raw frames to an external encoder.
+func ValidOutputFormat(format string) bool {
+	switch format {
+	case "", "gif", "png", "apng", "pipe":
+		return true
+	}
+
+	return false
+}
+
+// NewFrameSink creates the sink of an output format.
+// Input: the format, the name of the run the output files are named after, a frame rate (zero for the default)
+// and, for the "pipe" format, the command of the encoder and its arguments.
+// Output: the sink, or an error if it could not be created.
+func NewFrameSink(format, name string, frame_rate int, command []string) (FrameSink, error) {
+	if frame_rate <= 0 {
+		frame_rate = default_frame_rate
+	}
+
+	switch format {
+	case "", "gif":
+		return &GIFSink{name: name, images: make([]image.Image, 0)}, nil
+	case "png":
+		return NewPNGSequenceSink(name + ".frames")
+	case "apng":
+		return NewAPNGSink(name+".out.png", frame_rate)
+	case "pipe":
+		return NewPipeSink(command, name, frame_rate)
+	}
+
+	return nil, errors.New("unknown output format " + format)
+}
+
+// ImageListSink appends every frame to a slice of images, so that they can be used once the run is over.
+type ImageListSink struct {
+	images *[]image.Image
+}
+
+// WriteFrame and Close implement FrameSink.
+func (sink ImageListSink) WriteFrame(img image.Image) error {
+	*sink.images = append(*sink.images, img)
+	return nil
+}
+
+func (sink ImageListSink) Close() error {
+	return nil
+}
+
+// GIFSink keeps every frame and hands them to gifhelper on Close, since a GIF needs a palette for all of its frames.
+type GIFSink struct {
+	name   string
+	images []image.Image
+}
+
+// WriteFrame and Close implement FrameSink.
+func (sink *GIFSink) WriteFrame(img image.Image) error {
+	sink.images = append(sink.images, img)
+	return nil
+}
+
+func (sink *GIFSink) Close() error {
+	gifhelper.ImagesToGIF(sink.images, sink.name)
+	return nil
+}
+
+// PNGSequenceSink writes every frame to its own numbered PNG file in a directory.
+type PNGSequenceSink struct {
+	directory string
+	count     int
+	err       error
+}
+
+// NewPNGSequenceSink creates the directory of a PNG sequence.
+func NewPNGSequenceSink(directory string) (*PNGSequenceSink, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	return &PNGSequenceSink{directory: directory}, nil
+}
+
+// WriteFrame and Close implement FrameSink.
+func (sink *PNGSequenceSink) WriteFrame(img image.Image) error {
+	if sink.err != nil {
+		return sink.err
+	}
+
+	file, err := os.Create(filepath.Join(sink.directory, fmt.Sprintf("frame_%05d.png", sink.count)))
+	if err == nil {
+		err = png.Encode(file, img)
+		if close_err := file.Close(); err == nil {
+			err = close_err
+		}
+	}
+	sink.err = err
+	sink.count++
+
+	return err
+}
+
+func (sink *PNGSequenceSink) Close() error {
+	return sink.err
+}
+
+// APNGSink writes an animated PNG, one frame at a time. The number of frames is only known at the end,
+// so Close goes back to the animation control chunk at the start of the file to fill it in.
+// Every frame must have the size of the first one.
+type APNGSink struct {
+	file       *os.File
+	frame_rate int
+	width      int
+	height     int
+	count      int
+	sequence   uint32 // the sequence number of the next fcTL or fdAT chunk
+	err        error
+}
+
+// apng_actl_offset is the position of the acTL chunk: it follows the 8 byte signature and the 25 byte IHDR chunk.
+const apng_actl_offset = 33
+
+// NewAPNGSink creates the file of an animated PNG.
+func NewAPNGSink(filename string, frame_rate int) (*APNGSink, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APNGSink{file: file, frame_rate: frame_rate}, nil
+}
+
+// WriteFrame and Close implement FrameSink.
+func (sink *APNGSink) WriteFrame(img image.Image) error {
+	if sink.err != nil {
+		return sink.err
+	}
+	sink.err = sink.writeFrame(img)
+
+	return sink.err
+}
+
+func (sink *APNGSink) writeFrame(img image.Image) error {
+	bounds := img.Bounds()
+	if sink.count == 0 {
+		sink.width, sink.height = bounds.Dx(), bounds.Dy()
+		if _, err := sink.file.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+			return err
+		}
+		// 8 bit RGBA, deflate compression, adaptive filtering and no interlacing
+		ihdr := make([]byte, 13)
+		binary.BigEndian.PutUint32(ihdr[0:], uint32(sink.width))
+		binary.BigEndian.PutUint32(ihdr[4:], uint32(sink.height))
+		ihdr[8], ihdr[9] = 8, 6
+		if err := WriteChunk(sink.file, "IHDR", ihdr); err != nil {
+			return err
+		}
+		if err := WriteChunk(sink.file, "acTL", make([]byte, 8)); err != nil {
+			return err
+		}
+	} else if bounds.Dx() != sink.width || bounds.Dy() != sink.height {
+		return fmt.Errorf("frame %d is %dx%d but the animation is %dx%d", sink.count, bounds.Dx(), bounds.Dy(), sink.width, sink.height)
+	}
+
+	// the frame covers the whole canvas, replaces the previous one and lasts 1/frame_rate seconds
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], sink.sequence)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(sink.width))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(sink.height))
+	binary.BigEndian.PutUint16(fctl[20:], 1)
+	binary.BigEndian.PutUint16(fctl[22:], uint16(sink.frame_rate))
+	if err := WriteChunk(sink.file, "fcTL", fctl); err != nil {
+		return err
+	}
+	sink.sequence++
+
+	data, err := CompressFrame(NRGBAFrame(img))
+	if err != nil {
+		return err
+	}
+	if sink.count == 0 {
+		err = WriteChunk(sink.file, "IDAT", data)
+	} else {
+		fdat := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(fdat, sink.sequence)
+		err = WriteChunk(sink.file, "fdAT", append(fdat, data...))
+		sink.sequence++
+	}
+	sink.count++
+
+	return err
+}
+
+func (sink *APNGSink) Close() error {
+	if sink.err == nil && sink.count == 0 {
+		sink.err = errors.New("an animated PNG needs at least one frame")
+	}
+	if sink.err == nil {
+		sink.err = WriteChunk(sink.file, "IEND", nil)
+	}
+	if sink.err == nil {
+		// the animation has count frames and loops forever
+		actl := make([]byte, 8)
+		binary.BigEndian.PutUint32(actl, uint32(sink.count))
+		if _, err := sink.file.Seek(apng_actl_offset, io.SeekStart); err != nil {
+			sink.err = err
+		} else {
+			sink.err = WriteChunk(sink.file, "acTL", actl)
+		}
+	}
+	if err := sink.file.Close(); sink.err == nil {
+		sink.err = err
+	}
+
+	return sink.err
+}
+
+// WriteChunk writes a PNG chunk: its length, type, data and the CRC of its type and data.
+func WriteChunk(w io.Writer, kind string, data []byte) error {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	_, err := w.Write(chunk)
+
+	return err
+}
+
+// CompressFrame turns an image into PNG image data: every row is filtered by subtracting the pixel to its left,
+// which suits the smooth gradients of a heatmap, and the rows are compressed with zlib.
+func CompressFrame(img *image.NRGBA) ([]byte, error) {
+	var buffer bytes.Buffer
+	z := zlib.NewWriter(&buffer)
+	width := img.Bounds().Dx()
+	row := make([]byte, 1+4*width)
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		pixels := img.Pix[y*img.Stride : y*img.Stride+4*width]
+		row[0] = 1
+		for i := range pixels {
+			if i < 4 {
+				row[1+i] = pixels[i]
+			} else {
+				row[1+i] = pixels[i] - pixels[i-4]
+			}
+		}
+		if _, err := z.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// NRGBAFrame converts an image to non-premultiplied RGBA pixels starting at the origin, as PNG and raw video expect.
+func NRGBAFrame(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	if frame, ok := img.(*image.NRGBA); ok && bounds.Min == (image.Point{}) {
+		return frame
+	}
+	frame := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(frame, frame.Bounds(), img, bounds.Min, draw.Src)
+
+	return frame
+}
+
+// PipeSink streams every frame as raw RGBA pixels, row after row, to the standard input of an encoder such as ffmpeg.
+// The encoder is started with the first frame, once the size of the frames is known, and its arguments may refer to
+// {width}, {height}, {fps} and {name}. For example:
+//
+//	ffmpeg -y -f rawvideo -pix_fmt rgba -s {width}x{height} -r {fps} -i - -pix_fmt yuv420p {name}.mp4
+type PipeSink struct {
+	command    []string
+	name       string
+	frame_rate int
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	err        error
+}
+
+// NewPipeSink prepares to pipe frames to an encoder, which is given as a command and its arguments.
+func NewPipeSink(command []string, name string, frame_rate int) (*PipeSink, error) {
+	if len(command) == 0 {
+		return nil, errors.New("the pipe output needs the command of an encoder")
+	}
+
+	return &PipeSink{command: command, name: name, frame_rate: frame_rate}, nil
+}
+
+// WriteFrame and Close implement FrameSink.
+func (sink *PipeSink) WriteFrame(img image.Image) error {
+	if sink.err != nil {
+		return sink.err
+	}
+	frame := NRGBAFrame(img)
+	if sink.cmd == nil {
+		sink.err = sink.start(frame.Bounds().Dx(), frame.Bounds().Dy())
+		if sink.err != nil {
+			return sink.err
+		}
+	}
+	_, sink.err = sink.stdin.Write(frame.Pix[:frame.Stride*frame.Bounds().Dy()])
+
+	return sink.err
+}
+
+// start runs the encoder with the placeholders of its arguments filled in.
+func (sink *PipeSink) start(width, height int) error {
+	replacer := strings.NewReplacer("{width}", strconv.Itoa(width), "{height}", strconv.Itoa(height),
+		"{fps}", strconv.Itoa(sink.frame_rate), "{name}", sink.name)
+	args := make([]string, len(sink.command))
+	for i, arg := range sink.command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	sink.cmd = exec.Command(args[0], args[1:]...)
+	sink.cmd.Stdout, sink.cmd.Stderr = os.Stdout, os.Stderr
+	stdin, err := sink.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	sink.stdin = stdin
+
+	return sink.cmd.Start()
+}
+
+func (sink *PipeSink) Close() error {
+	if sink.cmd == nil || sink.cmd.Process == nil {
+		return sink.err
+	}
+	// closing the input tells the encoder that the video is over
+	if err := sink.stdin.Close(); sink.err == nil {
+		sink.err = err
+	}
+	if err := sink.cmd.Wait(); sink.err == nil {
+		sink.err = err
+	}
+
+	return sink.err
+}
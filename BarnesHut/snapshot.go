@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// snapshot_magic starts every binary snapshot, followed by the format version.
+const snapshot_magic = "BHSNAP"
+
+const snapshot_version = 1
+
+// SnapshotJSON is the human-readable form of a Universe snapshot.
+// The fields are exported only so that encoding/json can see them.
+type SnapshotJSON struct {
+	Generation   int        `json:"generation"`
+	Time         float64    `json:"time"`
+	Width        float64    `json:"width"`
+	Softening    string     `json:"softening"`
+	SofteningLen float64    `json:"softening_length"`
+	EscapePolicy string     `json:"escape_policy"`
+	Quadrupole   bool       `json:"quadrupole"`
+	Collisions   string     `json:"collision_policy"`
+	Integrator   string     `json:"integrator"`
+	Stars        []StarJSON `json:"stars"`
+}
+
+// StarJSON is the human-readable form of a Star.
+type StarJSON struct {
+	ID           int        `json:"id"`
+	Position     [2]float64 `json:"position"`
+	Velocity     [2]float64 `json:"velocity"`
+	Acceleration [2]float64 `json:"acceleration"`
+	Mass         float64    `json:"mass"`
+	Radius       float64    `json:"radius"`
+	Color        [3]uint8   `json:"color"`
+}
+
+// SaveSnapshot writes a Universe and its generation to a file.
+// Files ending in ".json" are written as JSON, every other file uses the compact binary encoding.
+// Input: a Universe object, a file name and the generation of the Universe.
+// Output: an error if the file could not be written.
+func (u *Universe) SaveSnapshot(filename string, generation int) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if filepath.Ext(filename) == ".json" {
+		err = u.WriteSnapshotJSON(w, generation)
+	} else {
+		err = u.WriteSnapshotBinary(w, generation)
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// LoadSnapshot reads a Universe and its generation from a file written by SaveSnapshot.
+// Input: a file name.
+// Output: the Universe object, its generation, and an error if the file could not be read.
+func LoadSnapshot(filename string) (*Universe, int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var u *Universe
+	var generation int
+	if filepath.Ext(filename) == ".json" {
+		u, generation, err = ReadSnapshotJSON(r)
+	} else {
+		u, generation, err = ReadSnapshotBinary(r)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if !u.softening.ValidSoftening() {
+		return nil, 0, errors.New("invalid softening " + u.softening.kernel + " of length " + strconv.FormatFloat(u.softening.length, 'g', -1, 64) + " in snapshot " + filename)
+	}
+	if !ValidEscapePolicy(u.escape_policy) {
+		return nil, 0, errors.New("unknown escape policy " + u.escape_policy + " in snapshot " + filename)
+	}
+	if !ValidCollisionPolicy(u.collision_policy) {
+		return nil, 0, errors.New("unknown collision policy " + u.collision_policy + " in snapshot " + filename)
+	}
+	if u.integrator != "" && !ValidIntegrator(u.integrator) {
+		return nil, 0, errors.New("unknown integrator " + u.integrator + " in snapshot " + filename)
+	}
+
+	return u, generation, nil
+}
+
+// SnapshotObserver creates an Observer that saves every frequency'th Universe to prefix.<generation>.snap.
+// Input: a file name prefix and a frequency.
+// Output: the Observer.
+func SnapshotObserver(prefix string, frequency int) Observer {
+	return func(generation int, u *Universe) {
+		if generation%frequency == 0 {
+			err := u.SaveSnapshot(prefix+"."+strconv.Itoa(generation)+".snap", generation)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// WriteSnapshotBinary encodes a Universe in little-endian binary: the magic string and version, the generation,
+// the elapsed time, the width, the softening, the escape policy, the quadrupole flag, the collision policy, the integrator,
+// and then every star as its id, eight float64 values and its three colors.
+// Input: a Universe object, a writer and the generation of the Universe.
+// Output: an error if writing failed.
+func (u *Universe) WriteSnapshotBinary(w io.Writer, generation int) error {
+	header := []any{
+		[]byte(snapshot_magic),
+		uint32(snapshot_version),
+		int64(generation),
+		u.time,
+		u.width,
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	if err := WriteString(w, u.softening.kernel); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, u.softening.length); err != nil {
+		return err
+	}
+	if err := WriteString(w, u.escape_policy); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, u.quadrupole); err != nil {
+		return err
+	}
+	if err := WriteString(w, u.collision_policy); err != nil {
+		return err
+	}
+	if err := WriteString(w, u.integrator); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(u.stars))); err != nil {
+		return err
+	}
+
+	for _, s := range u.stars {
+		if err := binary.Write(w, binary.LittleEndian, int64(s.id)); err != nil {
+			return err
+		}
+		values := [8]float64{s.position.x, s.position.y, s.velocity.x, s.velocity.y, s.acceleration.x, s.acceleration.y, s.mass, s.radius}
+		if err := binary.Write(w, binary.LittleEndian, values); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, [3]uint8{s.red, s.green, s.blue}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadSnapshotBinary decodes a Universe written by WriteSnapshotBinary.
+// Input: a reader.
+// Output: the Universe object, its generation, and an error if the data is not a valid snapshot.
+func ReadSnapshotBinary(r io.Reader) (*Universe, int, error) {
+	magic := make([]byte, len(snapshot_magic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, 0, err
+	}
+	if string(magic) != snapshot_magic {
+		return nil, 0, errors.New("not a BarnesHut snapshot")
+	}
+	var version uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, 0, err
+	}
+	if version != snapshot_version {
+		return nil, 0, errors.New("unsupported snapshot version " + strconv.Itoa(int(version)))
+	}
+
+	var u Universe
+	var generation int64
+	var num_stars uint64
+	var err error
+	if err = binary.Read(r, binary.LittleEndian, &generation); err != nil {
+		return nil, 0, err
+	}
+	if err = binary.Read(r, binary.LittleEndian, &u.time); err != nil {
+		return nil, 0, err
+	}
+	if err = binary.Read(r, binary.LittleEndian, &u.width); err != nil {
+		return nil, 0, err
+	}
+	if u.softening.kernel, err = ReadString(r); err != nil {
+		return nil, 0, err
+	}
+	if err = binary.Read(r, binary.LittleEndian, &u.softening.length); err != nil {
+		return nil, 0, err
+	}
+	if u.escape_policy, err = ReadString(r); err != nil {
+		return nil, 0, err
+	}
+	if err = binary.Read(r, binary.LittleEndian, &u.quadrupole); err != nil {
+		return nil, 0, err
+	}
+	if u.collision_policy, err = ReadString(r); err != nil {
+		return nil, 0, err
+	}
+	if u.integrator, err = ReadString(r); err != nil {
+		return nil, 0, err
+	}
+	if err = binary.Read(r, binary.LittleEndian, &num_stars); err != nil {
+		return nil, 0, err
+	}
+
+	u.stars = make([]*Star, 0)
+	for i := uint64(0); i < num_stars; i++ {
+		var id int64
+		var values [8]float64
+		var colors [3]uint8
+		if err = binary.Read(r, binary.LittleEndian, &id); err != nil {
+			return nil, 0, err
+		}
+		if err = binary.Read(r, binary.LittleEndian, &values); err != nil {
+			return nil, 0, err
+		}
+		if err = binary.Read(r, binary.LittleEndian, &colors); err != nil {
+			return nil, 0, err
+		}
+		var s Star
+		s.position = OrderedPair{values[0], values[1]}
+		s.velocity = OrderedPair{values[2], values[3]}
+		s.acceleration = OrderedPair{values[4], values[5]}
+		s.mass = values[6]
+		s.radius = values[7]
+		s.red, s.green, s.blue = colors[0], colors[1], colors[2]
+		s.id = int(id)
+		u.stars = append(u.stars, &s)
+	}
+	// stars written without an id are numbered after the others
+	u.AssignIDs()
+
+	return &u, int(generation), nil
+}
+
+// WriteString writes a string to a binary snapshot, preceded by its length.
+func WriteString(w io.Writer, str string) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(str))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, str)
+
+	return err
+}
+
+// ReadString reads a string written by WriteString.
+func ReadString(r io.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	if length > math.MaxUint16 {
+		return "", errors.New("corrupt string in snapshot")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+// WriteSnapshotJSON encodes a Universe as indented JSON.
+// Input: a Universe object, a writer and the generation of the Universe.
+// Output: an error if writing failed.
+func (u *Universe) WriteSnapshotJSON(w io.Writer, generation int) error {
+	var snapshot SnapshotJSON
+	snapshot.Generation = generation
+	snapshot.Time = u.time
+	snapshot.Width = u.width
+	snapshot.Softening = u.softening.kernel
+	snapshot.SofteningLen = u.softening.length
+	snapshot.EscapePolicy = u.escape_policy
+	snapshot.Quadrupole = u.quadrupole
+	snapshot.Collisions = u.collision_policy
+	snapshot.Integrator = u.integrator
+	snapshot.Stars = make([]StarJSON, len(u.stars))
+	for i, s := range u.stars {
+		snapshot.Stars[i] = StarJSON{
+			ID:           s.id,
+			Position:     [2]float64{s.position.x, s.position.y},
+			Velocity:     [2]float64{s.velocity.x, s.velocity.y},
+			Acceleration: [2]float64{s.acceleration.x, s.acceleration.y},
+			Mass:         s.mass,
+			Radius:       s.radius,
+			Color:        [3]uint8{s.red, s.green, s.blue},
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(snapshot)
+}
+
+// ReadSnapshotJSON decodes a Universe written by WriteSnapshotJSON.
+// Input: a reader.
+// Output: the Universe object, its generation, and an error if the data is not a valid snapshot.
+func ReadSnapshotJSON(r io.Reader) (*Universe, int, error) {
+	var snapshot SnapshotJSON
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, 0, err
+	}
+
+	var u Universe
+	u.time = snapshot.Time
+	u.width = snapshot.Width
+	u.softening = Softening{snapshot.Softening, snapshot.SofteningLen}
+	u.escape_policy = snapshot.EscapePolicy
+	u.quadrupole = snapshot.Quadrupole
+	u.collision_policy = snapshot.Collisions
+	u.integrator = snapshot.Integrator
+	u.stars = make([]*Star, len(snapshot.Stars))
+	for i, star := range snapshot.Stars {
+		var s Star
+		s.position = OrderedPair{star.Position[0], star.Position[1]}
+		s.velocity = OrderedPair{star.Velocity[0], star.Velocity[1]}
+		s.acceleration = OrderedPair{star.Acceleration[0], star.Acceleration[1]}
+		s.mass = star.Mass
+		s.radius = star.Radius
+		s.red, s.green, s.blue = star.Color[0], star.Color[1], star.Color[2]
+		s.id = star.ID
+		u.stars[i] = &s
+	}
+	u.AssignIDs()
+
+	return &u, snapshot.Generation, nil
+}
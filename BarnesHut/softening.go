@@ -0,0 +1,74 @@
+package main
+
+import "math"
+
+// Softening describes how gravity is smoothed at short range so that close encounters stay finite.
+// The kernel is "none" (or empty) for plain Newtonian gravity, "plummer" for Plummer softening,
+// or "spline" for the cubic spline kernel. The length is the Plummer-equivalent softening length,
+// so the spline kernel becomes exactly Newtonian beyond 2.8 times the length.
+type Softening struct {
+	kernel string
+	length float64
+}
+
+// ValidSoftening checks whether the kernel of a Softening is known and its length is usable.
+func (soft Softening) ValidSoftening() bool {
+	switch soft.kernel {
+	case "", "none":
+		return true
+	case "plummer", "spline":
+		return soft.length > 0
+	}
+
+	return false
+}
+
+// ForceFactor computes the softened counterpart of 1/d^3 for two stars at distance d.
+// The force between two stars is then G * m1 * m2 * ForceFactor(d) times their separation vector.
+// Input: the distance between two stars.
+// Output: the force factor.
+func (soft Softening) ForceFactor(d float64) float64 {
+	switch soft.kernel {
+	case "", "none":
+		return 1 / (d * d * d)
+	case "plummer":
+		r2 := d*d + soft.length*soft.length
+		return 1 / (r2 * math.Sqrt(r2))
+	case "spline":
+		h := 2.8 * soft.length
+		if d >= h {
+			return 1 / (d * d * d)
+		}
+		u := d / h
+		h_inv3 := 1 / (h * h * h)
+		if u < 0.5 {
+			return h_inv3 * (10.666666666667 + u*u*(32.0*u-38.4))
+		}
+		return h_inv3 * (21.333333333333 - 48.0*u + 38.4*u*u - 10.666666666667*u*u*u - 0.066666666667/(u*u*u))
+	}
+	panic("Error: unknown softening kernel " + soft.kernel + ".")
+}
+
+// PotentialFactor computes the softened counterpart of 1/d for two stars at distance d.
+// The potential energy between two stars is then -G * m1 * m2 * PotentialFactor(d).
+// Input: the distance between two stars.
+// Output: the potential factor.
+func (soft Softening) PotentialFactor(d float64) float64 {
+	switch soft.kernel {
+	case "", "none":
+		return 1 / d
+	case "plummer":
+		return 1 / math.Sqrt(d*d+soft.length*soft.length)
+	case "spline":
+		h := 2.8 * soft.length
+		if d >= h {
+			return 1 / d
+		}
+		u := d / h
+		if u < 0.5 {
+			return -(-2.8 + u*u*(5.333333333333+u*u*(6.4*u-9.6))) / h
+		}
+		return -(-3.2 + 0.066666666667/u + u*u*(10.666666666667+u*(-16.0+u*(9.6-2.133333333333*u)))) / h
+	}
+	panic("Error: unknown softening kernel " + soft.kernel + ".")
+}
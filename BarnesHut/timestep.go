@@ -0,0 +1,128 @@
+package main
+
+import "math"
+
+// default_eta is the accuracy parameter of the adaptive schemes: a star may move for eta * sqrt(L / |a|),
+// where L is the softening length of the universe (or a ten thousandth of its width without softening).
+const default_eta = 0.025
+
+// max_block_level bounds the hierarchy of block time steps: no star steps less than 1/2^max_block_level
+// of a generation, which also bounds the number of substeps of the global adaptive scheme.
+const max_block_level = 12
+
+// TimestepCriterion computes the largest time step allowed for a star with the given acceleration.
+// Input: a Universe object, the acceleration of a star and the accuracy parameter eta.
+// Output: the allowed time step, which is infinite for a star with no acceleration.
+func (u *Universe) TimestepCriterion(a OrderedPair, eta float64) float64 {
+	length := u.softening.length
+	if length <= 0 {
+		length = u.width * 1e-4
+	}
+
+	return eta * math.Sqrt(length/math.Hypot(a.x, a.y))
+}
+
+// AdaptiveStep creates an Integrator that splits every generation into leapfrog substeps whose common size is
+// chosen from the largest acceleration in the universe, so a generation still advances exactly the given time.
+// Input: the accuracy parameter eta.
+// Output: the corresponding Integrator.
+func AdaptiveStep(eta float64) Integrator {
+	return func(u *Universe, time float64, forces ForceFunction) {
+		min_step := time / float64(int(1)<<max_block_level)
+		remaining := time
+
+		for remaining > 0 {
+			step := remaining
+			for _, s := range u.stars {
+				step = math.Min(step, u.TimestepCriterion(s.acceleration, eta))
+			}
+			step = math.Max(step, min_step)
+			if step >= remaining {
+				step = remaining
+			}
+
+			LeapfrogStep(u, step, forces)
+			remaining -= step
+		}
+	}
+}
+
+// BlockStep creates an Integrator with hierarchical block time steps. Within a generation of length time,
+// every star gets its own step time/2^level, so stars deep in a potential well are kicked often
+// while the outer stars are kicked rarely. All stars drift together on the finest step, but only the stars
+// at the end of their own step have their forces recomputed, and every star is synchronized again at the end.
+// Input: the accuracy parameter eta.
+// Output: the corresponding Integrator.
+func BlockStep(eta float64) Integrator {
+	return func(u *Universe, time float64, forces ForceFunction) {
+		levels := make([]int, len(u.stars))
+		finest := 0
+		for i, s := range u.stars {
+			levels[i] = u.BlockLevel(s.acceleration, time, eta, max_block_level)
+			if levels[i] > finest {
+				finest = levels[i]
+			}
+		}
+
+		num_substeps := 1 << finest
+		substep := time / float64(num_substeps)
+		active := make([]*Star, 0, len(u.stars))
+		active_index := make([]int, 0, len(u.stars))
+
+		for m := 0; m < num_substeps; m++ {
+			// opening half kick for every star that starts its own step now
+			for i, s := range u.stars {
+				stride := 1 << (finest - levels[i])
+				if m%stride == 0 {
+					s.velocity = s.UpdateVelocity(time / float64(int(1)<<levels[i]) / 2)
+				}
+			}
+
+			// every star drifts with its half-step velocity
+			for _, s := range u.stars {
+				s.position.x += s.velocity.x * substep
+				s.position.y += s.velocity.y * substep
+			}
+
+			// closing half kick for every star that ends its own step now
+			active = active[:0]
+			active_index = active_index[:0]
+			for i, s := range u.stars {
+				stride := 1 << (finest - levels[i])
+				if (m+1)%stride == 0 {
+					active = append(active, s)
+					active_index = append(active_index, i)
+				}
+			}
+			if len(active) == 0 {
+				continue
+			}
+			forces(u, active)
+			for k, s := range active {
+				i := active_index[k]
+				s.velocity = s.UpdateVelocity(time / float64(int(1)<<levels[i]) / 2)
+
+				// a star may move to a finer step at any time, but only to a coarser step that is also synchronized now
+				new_level := u.BlockLevel(s.acceleration, time, eta, finest)
+				for new_level < levels[i] && (m+1)%(1<<(finest-new_level)) != 0 {
+					new_level++
+				}
+				levels[i] = new_level
+			}
+		}
+	}
+}
+
+// BlockLevel chooses the level of a star in the block hierarchy: the smallest level whose step time/2^level
+// satisfies TimestepCriterion, but no more than max_level.
+// Input: a Universe object, the acceleration of a star, the length of a generation, eta and the finest level allowed.
+// Output: the level of the star.
+func (u *Universe) BlockLevel(a OrderedPair, time, eta float64, max_level int) int {
+	allowed := u.TimestepCriterion(a, eta)
+	level := 0
+	for level < max_level && time/float64(int(1)<<level) > allowed {
+		level++
+	}
+
+	return level
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// TrajectoryPoint is the state of a tracked star at one generation.
+type TrajectoryPoint struct {
+	generation         int
+	time               float64
+	position, velocity OrderedPair
+}
+
+// Trajectories holds the recorded path of every tracked star, keyed by the id of the star.
+// A star is tracked by giving it an entry, which NewTrajectories does for a list of ids.
+type Trajectories map[int][]TrajectoryPoint
+
+// NewTrajectories marks the stars with the given ids for tracking.
+func NewTrajectories(ids []int) Trajectories {
+	trajectories := make(Trajectories, len(ids))
+	for _, id := range ids {
+		trajectories[id] = make([]TrajectoryPoint, 0)
+	}
+
+	return trajectories
+}
+
+// TrajectoryObserver creates an Observer that appends the position and velocity of every tracked star
+// to its trajectory every frequency generations. A star that has left the universe simply stops being recorded.
+func TrajectoryObserver(trajectories Trajectories, frequency int) Observer {
+	return func(generation int, u *Universe) {
+		if generation%frequency != 0 {
+			return
+		}
+		for _, s := range u.stars {
+			if path, tracked := trajectories[s.id]; tracked {
+				trajectories[s.id] = append(path, TrajectoryPoint{generation, u.time, s.position, s.velocity})
+			}
+		}
+	}
+}
+
+// WriteTrajectories writes the trajectories to a CSV file, one row per star and generation, ordered by id.
+// Input: the trajectories and a file name.
+// Output: an error if the file could not be written.
+func WriteTrajectories(trajectories Trajectories, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ids := make([]int, 0, len(trajectories))
+	for id := range trajectories {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"id", "generation", "time", "x", "y", "vx", "vy"})
+	for _, id := range ids {
+		for _, p := range trajectories[id] {
+			w.Write([]string{
+				strconv.Itoa(id),
+				strconv.Itoa(p.generation),
+				FormatFloat(p.time),
+				FormatFloat(p.position.x),
+				FormatFloat(p.position.y),
+				FormatFloat(p.velocity.x),
+				FormatFloat(p.velocity.y),
+			})
+		}
+	}
+	w.Flush()
+
+	return w.Error()
+}
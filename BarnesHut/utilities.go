@@ -10,7 +10,7 @@ func (current_node *Node) CopyNode() *Node {
 	}
 	star_copy := current_node.star.CopyStar()
 	sector_copy := Quadrant{current_node.sector.x, current_node.sector.y, current_node.sector.width}
-	n := Node{children_copy, star_copy, sector_copy}
+	n := Node{children_copy, star_copy, sector_copy, current_node.quadrupole}
 
 	return &n
 }
@@ -30,6 +30,7 @@ func (current_star *Star) CopyStar() *Star {
 	new_star.red = current_star.red
 	new_star.blue = current_star.blue
 	new_star.green = current_star.green
+	new_star.id = current_star.id
 
 	return &new_star
 }
@@ -38,6 +39,14 @@ func (current_star *Star) CopyStar() *Star {
 func (current_universe *Universe) CopyUniverse() *Universe {
 	var new_universe Universe
 	new_universe.width = current_universe.width
+	new_universe.softening = current_universe.softening
+	new_universe.escape_policy = current_universe.escape_policy
+	new_universe.escapes = append([]EscapeEvent(nil), current_universe.escapes...)
+	new_universe.quadrupole = current_universe.quadrupole
+	new_universe.collision_policy = current_universe.collision_policy
+	new_universe.mergers = append([]MergerEvent(nil), current_universe.mergers...)
+	new_universe.integrator = current_universe.integrator
+	new_universe.time = current_universe.time
 	new_universe.stars = make([]*Star, len(current_universe.stars))
 	for i := range new_universe.stars {
 		new_universe.stars[i] = current_universe.stars[i].CopyStar()
@@ -55,6 +64,16 @@ func (s1 *Star) IsSameStar(s2 *Star) bool {
 	return false
 }
 
+// IsSelf checks whether other is star s itself or a copy of it, such as the stars held by the quadtree.
+// Stars with an id are compared by id; only stars that never got one fall back to IsSameStar.
+func (s *Star) IsSelf(other *Star) bool {
+	if s.id != 0 || other.id != 0 {
+		return s.id == other.id
+	}
+
+	return s == other || s.IsSameStar(other)
+}
+
 // Distance calculates the distance between two stars.
 func Distance(p1, p2 OrderedPair) float64 {
 	deltaX := p1.x - p2.x